@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"k8s.io/client-go/transport"
+)
+
+// clientTTL is how long a context's clients are reused before they are
+// rebuilt from the kubeconfig, picking up rotated certificates and tokens.
+// Zero keeps them until they are invalidated.
+var clientTTL = time.Hour
+
+// unauthorizedGracePeriod keeps freshly built clients from being evicted
+// again and again while a cluster keeps rejecting their credentials.
+const unauthorizedGracePeriod = 30 * time.Second
+
+// expiredLocked reports whether the clients of a context outlived clientTTL.
+// cm.mu must be held.
+func (cm *ClusterManager) expiredLocked(ctxName string) bool {
+	builtAt, ok := cm.built[ctxName]
+	return ok && clientTTL > 0 && time.Since(builtAt) > clientTTL
+}
+
+// evictOnUnauthorized wraps the transport of clients built at builtAt so a
+// 401 response drops them, and the next request rebuilds them with fresh
+// credentials.
+func (cm *ClusterManager) evictOnUnauthorized(ctxName string, builtAt time.Time) transport.WrapperFunc {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := rt.RoundTrip(req)
+			if err == nil && resp.StatusCode == http.StatusUnauthorized && time.Since(builtAt) > unauthorizedGracePeriod {
+				cm.evictClients(ctxName, builtAt)
+			}
+			return resp, err
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// evictClients drops the clients of a context if they are still the ones
+// built at builtAt, so concurrent failures only trigger one rebuild.
+func (cm *ClusterManager) evictClients(ctxName string, builtAt time.Time) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if current, ok := cm.built[ctxName]; !ok || !current.Equal(builtAt) {
+		return
+	}
+	log.Printf("Context %s rejected its credentials, rebuilding its clients", ctxName)
+	cm.invalidateLocked(ctxName)
+}
+
+// handleRefreshClients drops cached clients so they are rebuilt on the next
+// request: those of the request's context, or of every context with all=true.
+func (cm *ClusterManager) handleRefreshClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	contexts, _ := cm.contextList()
+	if r.URL.Query().Get("all") != "true" {
+		ctxName := cm.contextNameForRequest(r)
+		if !cm.contextExists(ctxName) {
+			http.Error(w, fmt.Sprintf("unknown context %q", ctxName), http.StatusNotFound)
+			return
+		}
+		contexts = []string{ctxName}
+	}
+
+	cm.mu.Lock()
+	for _, ctxName := range contexts {
+		cm.invalidateLocked(ctxName)
+	}
+	cm.mu.Unlock()
+	log.Printf("Refreshed clients for contexts: %v", contexts)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"refreshed": contexts})
+}
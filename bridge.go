@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsWriteWait bounds every write to the browser.
+	wsWriteWait = 10 * time.Second
+	// consoleReadChunk is the largest frame forwarded from the upstream stream.
+	consoleReadChunk = 32 * 1024
+	// consoleOutputQueue is how many upstream chunks may wait for a slow
+	// client. While the queue is full the upstream is paused; a client that
+	// does not drain it within consoleSlowClientTimeout is disconnected.
+	consoleOutputQueue       = 64
+	consoleSlowClientTimeout = 30 * time.Second
+
+	closeSlowClient = 4002
+)
+
+type bridgeOptions struct {
+	name string
+	// kind labels the session metrics: serial, vnc or exec.
+	kind string
+	// vnc marks raw RFB sessions, which must not receive text frames.
+	vnc bool
+	// initialInput is written to the upstream once the bridge starts.
+	initialInput []byte
+	// errorPrefix labels upstream errors sent to the client as text.
+	errorPrefix string
+	// doneReason is the close reason when the upstream ends cleanly.
+	doneReason string
+}
+
+// bridgeConsole pumps data between a console websocket and an upstream
+// stream until either side ends. start must block for the lifetime of the
+// upstream session, reading input from stdin and writing output to stdout.
+// The client is pinged every keepalive interval, and the reason the session
+// ended is delivered as a websocket close frame.
+func bridgeConsole(ctx context.Context, conn *consoleConn, opts bridgeOptions, start func(ctx context.Context, stdin io.Reader, stdout io.Writer) error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	websocketSessions.WithLabelValues(opts.kind).Inc()
+	defer websocketSessions.WithLabelValues(opts.kind).Dec()
+	bytesIn := consoleBytes.WithLabelValues(opts.kind, "in")
+	bytesOut := consoleBytes.WithLabelValues(opts.kind, "out")
+
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+	defer stdinWriter.Close()
+	defer stdoutReader.Close()
+
+	// Each of the four goroutines below reports at most once.
+	end := make(chan *websocket.CloseError, 4)
+
+	go func() {
+		err := start(ctx, stdinReader, stdoutWriter)
+		stdoutWriter.CloseWithError(err)
+	}()
+	if len(opts.initialInput) > 0 {
+		go stdinWriter.Write(opts.initialInput)
+	}
+
+	monitor := newSessionMonitor(consoleLimits)
+	go func() {
+		ce := monitor.run(ctx, func(msg string) {
+			// Text frames would corrupt the RFB stream, the VNC page warns on its own.
+			if !opts.vnc {
+				_ = conn.writeMessage(websocket.TextMessage, []byte(msg))
+			}
+		})
+		if ce != nil {
+			end <- ce
+		}
+	}()
+
+	// Upstream output is queued so a briefly slow client does not stall the
+	// upstream, while the queue bound keeps memory per session fixed.
+	out := make(chan []byte, consoleOutputQueue)
+	var upstreamErr error
+	go func() {
+		defer close(out)
+		for {
+			buffer := make([]byte, consoleReadChunk)
+			n, err := stdoutReader.Read(buffer)
+			if n > 0 && !enqueueOutput(ctx, out, buffer[:n]) {
+				if ctx.Err() == nil {
+					end <- &websocket.CloseError{Code: closeSlowClient, Text: "client is not reading console output fast enough"}
+				}
+				return
+			}
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					upstreamErr = err
+				}
+				return
+			}
+		}
+	}()
+
+	go func() {
+		// Without a keepalive interval no pings are sent.
+		var pings <-chan time.Time
+		if consoleLimits.KeepAlive > 0 {
+			ticker := time.NewTicker(consoleLimits.KeepAlive)
+			defer ticker.Stop()
+			pings = ticker.C
+		}
+		for {
+			select {
+			case chunk, ok := <-out:
+				if !ok {
+					end <- upstreamClose(conn, opts, upstreamErr)
+					return
+				}
+				if err := conn.writeMessage(websocket.BinaryMessage, chunk); err != nil {
+					end <- nil
+					return
+				}
+				bytesOut.Add(float64(len(chunk)))
+			case <-pings:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+					end <- nil
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Clients that are not pinged have no read deadline either.
+	pongWait := 2 * consoleLimits.KeepAlive
+	extendReadDeadline := func() error {
+		if pongWait <= 0 {
+			return nil
+		}
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	}
+	_ = extendReadDeadline()
+	conn.SetPongHandler(func(string) error { return extendReadDeadline() })
+	go func() {
+		for {
+			messageType, payload, err := conn.ReadMessage()
+			if err != nil {
+				end <- nil
+				return
+			}
+			_ = extendReadDeadline()
+			if messageType != websocket.TextMessage && messageType != websocket.BinaryMessage {
+				continue
+			}
+			if !opts.vnc || !isVNCUpdateRequest(payload) {
+				monitor.touch()
+			}
+			bytesIn.Add(float64(len(payload)))
+			if _, err := stdinWriter.Write(payload); err != nil {
+				end <- &websocket.CloseError{Code: websocket.CloseInternalServerErr, Text: "console input closed"}
+				return
+			}
+		}
+	}()
+
+	if ce := <-end; ce != nil {
+		log.Printf("Closing %s: %s", opts.name, ce.Text)
+		conn.closeWithReason(ce.Code, ce.Text)
+	}
+}
+
+func enqueueOutput(ctx context.Context, out chan<- []byte, chunk []byte) bool {
+	select {
+	case out <- chunk:
+		return true
+	default:
+	}
+	timer := time.NewTimer(consoleSlowClientTimeout)
+	defer timer.Stop()
+	select {
+	case out <- chunk:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// upstreamClose reports how the upstream ended. Errors are also sent as a
+// text frame, which the console pages display, unless the session is VNC.
+func upstreamClose(conn *consoleConn, opts bridgeOptions, err error) *websocket.CloseError {
+	if err == nil {
+		return &websocket.CloseError{Code: websocket.CloseNormalClosure, Text: opts.doneReason}
+	}
+	msg := opts.errorPrefix + ": " + err.Error()
+	if !opts.vnc {
+		_ = conn.writeMessage(websocket.TextMessage, []byte(msg))
+	}
+	return &websocket.CloseError{Code: websocket.CloseInternalServerErr, Text: msg}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kvv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+)
+
+// defaultCPUAllocationRatio is KubeVirt's cpuAllocationRatio when the
+// KubeVirt CR does not set one: each vCPU requests a tenth of a CPU.
+const defaultCPUAllocationRatio = 10
+
+// resourceAmounts are CPU, memory and hugepages, keyed by resource name such
+// as hugepages-2Mi.
+type resourceAmounts struct {
+	CPUMillis   int64            `json:"cpuMillis"`
+	MemoryBytes int64            `json:"memoryBytes"`
+	Hugepages   map[string]int64 `json:"hugepages,omitempty"`
+}
+
+func (a *resourceAmounts) add(b resourceAmounts) {
+	a.CPUMillis += b.CPUMillis
+	a.MemoryBytes += b.MemoryBytes
+	for name, bytes := range b.Hugepages {
+		if a.Hugepages == nil {
+			a.Hugepages = make(map[string]int64)
+		}
+		a.Hugepages[name] += bytes
+	}
+}
+
+// capacityUsage compares what nodes offer with what their VMIs request.
+// VCPUs counts guest CPUs, so CPUOvercommit is VCPUs per allocatable CPU.
+type capacityUsage struct {
+	Allocatable      resourceAmounts `json:"allocatable"`
+	Requested        resourceAmounts `json:"requested"`
+	VCPUs            int64           `json:"vcpus"`
+	VMIs             int             `json:"vmis"`
+	CPUOvercommit    float64         `json:"cpuOvercommit"`
+	MemoryCommitment float64         `json:"memoryCommitment"`
+}
+
+type nodeCapacity struct {
+	Name        string `json:"name"`
+	Schedulable bool   `json:"schedulable"`
+	capacityUsage
+}
+
+func (u *capacityUsage) computeRatios() {
+	if u.Allocatable.CPUMillis > 0 {
+		u.CPUOvercommit = float64(u.VCPUs*1000) / float64(u.Allocatable.CPUMillis)
+	}
+	if u.Allocatable.MemoryBytes > 0 {
+		u.MemoryCommitment = float64(u.Requested.MemoryBytes) / float64(u.Allocatable.MemoryBytes)
+	}
+}
+
+// storageClassCapacity sums the CSIStorageCapacity objects of a storage
+// class across topology segments.
+type storageClassCapacity struct {
+	Name                   string `json:"name"`
+	CapacityBytes          int64  `json:"capacityBytes"`
+	MaximumVolumeSizeBytes int64  `json:"maximumVolumeSizeBytes,omitempty"`
+	Segments               int    `json:"segments"`
+}
+
+// instanceTypeUsage counts running VMIs per instance type.
+type instanceTypeUsage struct {
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`
+	VMIs        int    `json:"vmis"`
+	VCPUs       int64  `json:"vcpus"`
+	MemoryBytes int64  `json:"memoryBytes"`
+}
+
+type clusterCapacity struct {
+	Context        string                 `json:"context"`
+	Total          capacityUsage          `json:"total"`
+	Nodes          []nodeCapacity         `json:"nodes"`
+	StorageClasses []storageClassCapacity `json:"storageClasses"`
+	InstanceTypes  []instanceTypeUsage    `json:"instanceTypes"`
+	// Pending counts VMIs not scheduled to a node yet.
+	Pending int `json:"pending"`
+	// OnMissingNodes counts VMIs scheduled to nodes that are not listed,
+	// such as nodes deleted while the VMI still exists.
+	OnMissingNodes     int      `json:"onMissingNodes"`
+	CPUAllocationRatio int      `json:"cpuAllocationRatio"`
+	Warnings           []string `json:"warnings,omitempty"`
+}
+
+// handleCapacity reports node capacity against VMI requests, hugepages and
+// storage class capacity. contexts selects a comma separated list of
+// contexts, by default the request's context; contexts that fail are
+// reported in errors.
+func (cm *ClusterManager) handleCapacity(w http.ResponseWriter, r *http.Request) {
+	contexts := []string{cm.contextNameForRequest(r)}
+	if v := r.URL.Query().Get("contexts"); v != "" {
+		var err error
+		if contexts, err = cm.selectContexts(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	items := make([]clusterCapacity, 0, len(contexts))
+	errs := make([]fleetError, 0)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, ctxName := range contexts {
+		wg.Add(1)
+		go func(ctxName string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), fleetClusterTimeout)
+			defer cancel()
+			capacity, err := cm.clusterCapacity(ctx, ctxName)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fleetError{Cluster: ctxName, Error: err.Error()})
+				return
+			}
+			items = append(items, capacity)
+		}(ctxName)
+	}
+	wg.Wait()
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Context < items[j].Context })
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Cluster < errs[j].Cluster })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": items, "errors": errs})
+}
+
+func (cm *ClusterManager) clusterCapacity(ctx context.Context, ctxName string) (clusterCapacity, error) {
+	result := clusterCapacity{Context: ctxName, Nodes: []nodeCapacity{}, StorageClasses: []storageClassCapacity{}, InstanceTypes: []instanceTypeUsage{}}
+	client, _, _, err := cm.clientsFor(ctxName)
+	if err != nil {
+		return result, err
+	}
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return result, fmt.Errorf("list nodes: %v", err)
+	}
+	vmis, err := cm.listVMIs(ctx, ctxName, client)
+	if err != nil {
+		return result, fmt.Errorf("list VMIs: %v", err)
+	}
+	result.CPUAllocationRatio, err = cpuAllocationRatio(ctx, client)
+	if err != nil {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("read cpuAllocationRatio, assuming %d: %v", defaultCPUAllocationRatio, err))
+	}
+
+	byNode := make(map[string]*nodeCapacity, len(nodes.Items))
+	for _, node := range nodes.Items {
+		result.Nodes = append(result.Nodes, nodeCapacity{
+			Name:          node.Name,
+			Schedulable:   !node.Spec.Unschedulable,
+			capacityUsage: capacityUsage{Allocatable: amountsOf(node.Status.Allocatable)},
+		})
+	}
+	for i := range result.Nodes {
+		byNode[result.Nodes[i].Name] = &result.Nodes[i]
+	}
+
+	instanceTypes := make(map[string]*instanceTypeUsage)
+	for i := range vmis {
+		vmi := &vmis[i]
+		if vmi.IsFinal() {
+			continue
+		}
+		requested, vcpus := vmiRequests(vmi, result.CPUAllocationRatio)
+		if node, ok := byNode[vmi.Status.NodeName]; ok {
+			node.Requested.add(requested)
+			node.VCPUs += vcpus
+			node.VMIs++
+		} else if vmi.Status.NodeName == "" {
+			result.Pending++
+		} else {
+			result.OnMissingNodes++
+		}
+		name, kind := vmi.Annotations[kvv1.InstancetypeAnnotation], "VirtualMachineInstancetype"
+		if name == "" {
+			name, kind = vmi.Annotations[kvv1.ClusterInstancetypeAnnotation], "VirtualMachineClusterInstancetype"
+		}
+		if name == "" {
+			continue
+		}
+		usage, ok := instanceTypes[kind+"/"+name]
+		if !ok {
+			usage = &instanceTypeUsage{Name: name, Kind: kind}
+			instanceTypes[kind+"/"+name] = usage
+		}
+		usage.VMIs++
+		usage.VCPUs += vcpus
+		usage.MemoryBytes += requested.MemoryBytes + sumHugepages(requested)
+	}
+
+	for i := range result.Nodes {
+		node := &result.Nodes[i]
+		node.computeRatios()
+		result.Total.Allocatable.add(node.Allocatable)
+		result.Total.Requested.add(node.Requested)
+		result.Total.VCPUs += node.VCPUs
+		result.Total.VMIs += node.VMIs
+	}
+	result.Total.computeRatios()
+	sort.Slice(result.Nodes, func(i, j int) bool { return result.Nodes[i].Name < result.Nodes[j].Name })
+	for _, usage := range instanceTypes {
+		result.InstanceTypes = append(result.InstanceTypes, *usage)
+	}
+	sort.Slice(result.InstanceTypes, func(i, j int) bool {
+		return result.InstanceTypes[i].Kind+"/"+result.InstanceTypes[i].Name < result.InstanceTypes[j].Kind+"/"+result.InstanceTypes[j].Name
+	})
+
+	// Storage capacity is optional: older clusters and CSI drivers without
+	// capacity tracking do not publish CSIStorageCapacity objects.
+	capacities, err := client.StorageV1().CSIStorageCapacities(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("list CSIStorageCapacities: %v", err))
+		return result, nil
+	}
+	byClass := make(map[string]*storageClassCapacity)
+	for _, capacity := range capacities.Items {
+		class, ok := byClass[capacity.StorageClassName]
+		if !ok {
+			class = &storageClassCapacity{Name: capacity.StorageClassName}
+			byClass[capacity.StorageClassName] = class
+		}
+		class.Segments++
+		if capacity.Capacity != nil {
+			class.CapacityBytes += capacity.Capacity.Value()
+		}
+		if capacity.MaximumVolumeSize != nil && capacity.MaximumVolumeSize.Value() > class.MaximumVolumeSizeBytes {
+			class.MaximumVolumeSizeBytes = capacity.MaximumVolumeSize.Value()
+		}
+	}
+	for _, class := range byClass {
+		result.StorageClasses = append(result.StorageClasses, *class)
+	}
+	sort.Slice(result.StorageClasses, func(i, j int) bool { return result.StorageClasses[i].Name < result.StorageClasses[j].Name })
+	return result, nil
+}
+
+// listVMIs returns the VMIs of a context from its VM cache when synced,
+// otherwise from the API server.
+func (cm *ClusterManager) listVMIs(ctx context.Context, ctxName string, client kubecli.KubevirtClient) ([]kvv1.VirtualMachineInstance, error) {
+	vmCache, err := cm.vmCacheFor(ctxName)
+	if err != nil {
+		return nil, err
+	}
+	if vmCache != nil && vmCache.waitForSync(ctx, vmCacheSyncTimeout) {
+		objs := vmCache.vmis.GetStore().List()
+		vmis := make([]kvv1.VirtualMachineInstance, 0, len(objs))
+		for _, obj := range objs {
+			if vmi, ok := obj.(*kvv1.VirtualMachineInstance); ok {
+				vmis = append(vmis, *vmi)
+			}
+		}
+		return vmis, nil
+	}
+	list, err := client.VirtualMachineInstance(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// amountsOf converts a resource list, e.g. node allocatable.
+func amountsOf(list corev1.ResourceList) resourceAmounts {
+	amounts := resourceAmounts{
+		CPUMillis:   list.Cpu().MilliValue(),
+		MemoryBytes: list.Memory().Value(),
+	}
+	for name, quantity := range list {
+		if strings.HasPrefix(string(name), corev1.ResourceHugePagesPrefix) && !quantity.IsZero() {
+			if amounts.Hugepages == nil {
+				amounts.Hugepages = make(map[string]int64)
+			}
+			amounts.Hugepages[string(name)] = quantity.Value()
+		}
+	}
+	return amounts
+}
+
+// cpuAllocationRatio reads the cpuAllocationRatio of the KubeVirt CR. The
+// default is returned with any error.
+func cpuAllocationRatio(ctx context.Context, client kubecli.KubevirtClient) (int, error) {
+	list, err := client.KubeVirt(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return defaultCPUAllocationRatio, err
+	}
+	for _, kv := range list.Items {
+		if dev := kv.Spec.Configuration.DeveloperConfiguration; dev != nil && dev.CPUAllocationRatio > 0 {
+			return dev.CPUAllocationRatio, nil
+		}
+	}
+	return defaultCPUAllocationRatio, nil
+}
+
+// vmiRequests returns what a VMI asks of its node and its guest CPU count.
+// Without an explicit CPU request virt-launcher requests 1/ratio of a CPU
+// per vCPU, or whole CPUs for dedicated CPU placement. Without an explicit
+// memory request the guest memory counts, which is where instance types end
+// up. Hugepages-backed guest memory is accounted as hugepages instead of
+// memory.
+func vmiRequests(vmi *kvv1.VirtualMachineInstance, cpuAllocationRatio int) (resourceAmounts, int64) {
+	domain := &vmi.Spec.Domain
+	vcpus := int64(1)
+	if cpu := domain.CPU; cpu != nil && cpu.Cores+cpu.Sockets+cpu.Threads > 0 {
+		vcpus = int64(max(cpu.Cores, 1)) * int64(max(cpu.Sockets, 1)) * int64(max(cpu.Threads, 1))
+	}
+	var amounts resourceAmounts
+	switch request, ok := domain.Resources.Requests[corev1.ResourceCPU]; {
+	case ok:
+		amounts.CPUMillis = request.MilliValue()
+	case domain.CPU != nil && domain.CPU.DedicatedCPUPlacement:
+		amounts.CPUMillis = vcpus * 1000
+	default:
+		amounts.CPUMillis = vcpus * 1000 / int64(max(cpuAllocationRatio, 1))
+	}
+
+	memory := resource.Quantity{}
+	if request, ok := domain.Resources.Requests[corev1.ResourceMemory]; ok {
+		memory = request
+	} else if domain.Memory != nil && domain.Memory.Guest != nil {
+		memory = *domain.Memory.Guest
+	}
+	if domain.Memory != nil && domain.Memory.Hugepages != nil && domain.Memory.Hugepages.PageSize != "" {
+		amounts.Hugepages = map[string]int64{corev1.ResourceHugePagesPrefix + domain.Memory.Hugepages.PageSize: memory.Value()}
+	} else {
+		amounts.MemoryBytes = memory.Value()
+	}
+	return amounts, vcpus
+}
+
+func sumHugepages(amounts resourceAmounts) int64 {
+	var total int64
+	for _, bytes := range amounts.Hugepages {
+		total += bytes
+	}
+	return total
+}
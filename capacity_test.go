@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	kvv1 "kubevirt.io/api/core/v1"
+)
+
+func TestVMIRequests(t *testing.T) {
+	quantity := resource.MustParse
+	tests := []struct {
+		name      string
+		domain    kvv1.DomainSpec
+		ratio     int
+		want      resourceAmounts
+		wantVCPUs int64
+	}{
+		{
+			name:      "no topology defaults to one vCPU",
+			domain:    kvv1.DomainSpec{Memory: &kvv1.Memory{Guest: quantityPtr(quantity("2Gi"))}},
+			ratio:     defaultCPUAllocationRatio,
+			want:      resourceAmounts{CPUMillis: 100, MemoryBytes: 2 << 30},
+			wantVCPUs: 1,
+		},
+		{
+			name: "vCPUs are charged by the allocation ratio",
+			domain: kvv1.DomainSpec{
+				CPU:    &kvv1.CPU{Cores: 2, Sockets: 2},
+				Memory: &kvv1.Memory{Guest: quantityPtr(quantity("4Gi"))},
+			},
+			ratio:     defaultCPUAllocationRatio,
+			want:      resourceAmounts{CPUMillis: 400, MemoryBytes: 4 << 30},
+			wantVCPUs: 4,
+		},
+		{
+			name:      "custom allocation ratio",
+			domain:    kvv1.DomainSpec{CPU: &kvv1.CPU{Cores: 4}},
+			ratio:     2,
+			want:      resourceAmounts{CPUMillis: 2000},
+			wantVCPUs: 4,
+		},
+		{
+			name:      "dedicated CPUs request whole CPUs",
+			domain:    kvv1.DomainSpec{CPU: &kvv1.CPU{Cores: 2, DedicatedCPUPlacement: true}},
+			ratio:     defaultCPUAllocationRatio,
+			want:      resourceAmounts{CPUMillis: 2000},
+			wantVCPUs: 2,
+		},
+		{
+			name: "explicit requests win",
+			domain: kvv1.DomainSpec{
+				CPU:    &kvv1.CPU{Cores: 2, Threads: 2},
+				Memory: &kvv1.Memory{Guest: quantityPtr(quantity("4Gi"))},
+				Resources: kvv1.ResourceRequirements{Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    quantity("1500m"),
+					corev1.ResourceMemory: quantity("5Gi"),
+				}},
+			},
+			ratio:     defaultCPUAllocationRatio,
+			want:      resourceAmounts{CPUMillis: 1500, MemoryBytes: 5 << 30},
+			wantVCPUs: 4,
+		},
+		{
+			name: "hugepages replace memory",
+			domain: kvv1.DomainSpec{
+				Memory: &kvv1.Memory{Guest: quantityPtr(quantity("8Gi")), Hugepages: &kvv1.Hugepages{PageSize: "1Gi"}},
+			},
+			ratio:     defaultCPUAllocationRatio,
+			want:      resourceAmounts{CPUMillis: 100, Hugepages: map[string]int64{"hugepages-1Gi": 8 << 30}},
+			wantVCPUs: 1,
+		},
+		{
+			name:      "invalid ratio counts as one",
+			domain:    kvv1.DomainSpec{CPU: &kvv1.CPU{Sockets: 2}},
+			ratio:     0,
+			want:      resourceAmounts{CPUMillis: 2000},
+			wantVCPUs: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vmi := &kvv1.VirtualMachineInstance{Spec: kvv1.VirtualMachineInstanceSpec{Domain: tt.domain}}
+			got, vcpus := vmiRequests(vmi, tt.ratio)
+			if !reflect.DeepEqual(got, tt.want) || vcpus != tt.wantVCPUs {
+				t.Errorf("vmiRequests() = %+v, %d, want %+v, %d", got, vcpus, tt.want, tt.wantVCPUs)
+			}
+		})
+	}
+}
+
+func quantityPtr(q resource.Quantity) *resource.Quantity { return &q }
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+// stripServerFields removes the fields the API server maintains, as shown
+// by /api/v1/yaml.
+func stripServerFields(obj *unstructured.Unstructured) {
+	obj.SetManagedFields(nil)
+	obj.SetResourceVersion("")
+	obj.SetGeneration(0)
+	obj.SetUID("")
+}
+
+// normalizeForDiff reduces an object to the configuration worth comparing
+// between clusters.
+func normalizeForDiff(obj *unstructured.Unstructured) {
+	stripServerFields(obj)
+	obj.SetSelfLink("")
+	obj.SetOwnerReferences(nil)
+	unstructured.RemoveNestedField(obj.Object, "status")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "creationTimestamp")
+	annotations := obj.GetAnnotations()
+	delete(annotations, corev1.LastAppliedConfigAnnotation)
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	obj.SetAnnotations(annotations)
+}
+
+type objectSide struct {
+	Context string `json:"context"`
+	Found   bool   `json:"found"`
+	Error   string `json:"error,omitempty"`
+	YAML    string `json:"yaml,omitempty"`
+}
+
+type fieldDifference struct {
+	Path  string      `json:"path"`
+	Left  interface{} `json:"left,omitempty"`
+	Right interface{} `json:"right,omitempty"`
+}
+
+type objectDiffResponse struct {
+	Left        objectSide        `json:"left"`
+	Right       objectSide        `json:"right"`
+	Equal       bool              `json:"equal"`
+	Differences []fieldDifference `json:"differences"`
+}
+
+// handleObjectDiff compares one object between the left and right contexts.
+// group, version and resource select the GVR; namespace is omitted for
+// cluster-scoped resources.
+func (cm *ClusterManager) handleObjectDiff(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	leftCtx, rightCtx := q.Get("left"), q.Get("right")
+	gvr := schema.GroupVersionResource{Group: q.Get("group"), Version: q.Get("version"), Resource: q.Get("resource")}
+	ns, name := q.Get("namespace"), q.Get("name")
+	if leftCtx == "" || rightCtx == "" || gvr.Version == "" || gvr.Resource == "" || name == "" {
+		http.Error(w, "left, right, version, resource and name are required", http.StatusBadRequest)
+		return
+	}
+	for _, ctxName := range []string{leftCtx, rightCtx} {
+		if !cm.contextExists(ctxName) {
+			http.Error(w, fmt.Sprintf("unknown context %q", ctxName), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var objects [2]*unstructured.Unstructured
+	sides := [2]objectSide{{Context: leftCtx}, {Context: rightCtx}}
+	var wg sync.WaitGroup
+	for i, ctxName := range []string{leftCtx, rightCtx} {
+		wg.Add(1)
+		go func(i int, ctxName string) {
+			defer wg.Done()
+			obj, err := cm.getNormalizedObject(r.Context(), ctxName, gvr, ns, name)
+			if err != nil {
+				if !apierrors.IsNotFound(err) {
+					sides[i].Error = err.Error()
+				}
+				return
+			}
+			data, _ := yaml.Marshal(obj.Object)
+			objects[i] = obj
+			sides[i].Found = true
+			sides[i].YAML = string(data)
+		}(i, ctxName)
+	}
+	wg.Wait()
+
+	resp := objectDiffResponse{Left: sides[0], Right: sides[1], Differences: []fieldDifference{}}
+	if objects[0] != nil && objects[1] != nil {
+		resp.Differences = diffValues("", objects[0].Object, objects[1].Object, resp.Differences)
+		resp.Equal = len(resp.Differences) == 0
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (cm *ClusterManager) getNormalizedObject(ctx context.Context, ctxName string, gvr schema.GroupVersionResource, ns, name string) (*unstructured.Unstructured, error) {
+	_, dynClient, _, err := cm.clientsFor(ctxName)
+	if err != nil {
+		return nil, err
+	}
+	var resource dynamic.ResourceInterface = dynClient.Resource(gvr)
+	if ns != "" {
+		resource = dynClient.Resource(gvr).Namespace(ns)
+	}
+	obj, err := resource.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	normalizeForDiff(obj)
+	return obj, nil
+}
+
+// diffValues appends the paths at which left and right differ. Maps are
+// compared key by key and lists element by element.
+func diffValues(path string, left, right interface{}, diffs []fieldDifference) []fieldDifference {
+	leftMap, leftIsMap := left.(map[string]interface{})
+	rightMap, rightIsMap := right.(map[string]interface{})
+	if leftIsMap && rightIsMap {
+		keys := make(map[string]struct{}, len(leftMap)+len(rightMap))
+		for key := range leftMap {
+			keys[key] = struct{}{}
+		}
+		for key := range rightMap {
+			keys[key] = struct{}{}
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+		for _, key := range sorted {
+			diffs = diffValues(joinPath(path, key), leftMap[key], rightMap[key], diffs)
+		}
+		return diffs
+	}
+
+	leftList, leftIsList := left.([]interface{})
+	rightList, rightIsList := right.([]interface{})
+	if leftIsList && rightIsList {
+		for i := 0; i < len(leftList) || i < len(rightList); i++ {
+			var l, r interface{}
+			if i < len(leftList) {
+				l = leftList[i]
+			}
+			if i < len(rightList) {
+				r = rightList[i]
+			}
+			diffs = diffValues(fmt.Sprintf("%s[%d]", path, i), l, r, diffs)
+		}
+		return diffs
+	}
+
+	if !reflect.DeepEqual(left, right) {
+		diffs = append(diffs, fieldDifference{Path: path, Left: left, Right: right})
+	}
+	return diffs
+}
+
+// joinPath appends a map key to a field path, quoting keys that contain dots
+// such as label and annotation names.
+func joinPath(path, key string) string {
+	if strings.ContainsAny(key, ".[]") {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// setDifference lists the entries found in only one of two contexts.
+type setDifference struct {
+	OnlyLeft  []string `json:"onlyLeft"`
+	OnlyRight []string `json:"onlyRight"`
+}
+
+type verbDifference struct {
+	APIVersion string   `json:"apiVersion"`
+	Resource   string   `json:"resource"`
+	OnlyLeft   []string `json:"onlyLeft"`
+	OnlyRight  []string `json:"onlyRight"`
+}
+
+type discoveryDiffResponse struct {
+	Left      string           `json:"left"`
+	Right     string           `json:"right"`
+	Groups    setDifference    `json:"groups"`
+	Versions  setDifference    `json:"versions"`
+	Resources setDifference    `json:"resources"`
+	Verbs     []verbDifference `json:"verbs"`
+}
+
+// handleDiscoveryDiff compares the APIs served by the left and right
+// contexts. Resources are reported as apiVersion/resource; verbs are only
+// compared for resources served by both.
+func (cm *ClusterManager) handleDiscoveryDiff(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	leftCtx, rightCtx := q.Get("left"), q.Get("right")
+	if leftCtx == "" || rightCtx == "" {
+		http.Error(w, "left and right are required", http.StatusBadRequest)
+		return
+	}
+	for _, ctxName := range []string{leftCtx, rightCtx} {
+		if !cm.contextExists(ctxName) {
+			http.Error(w, fmt.Sprintf("unknown context %q", ctxName), http.StatusBadRequest)
+			return
+		}
+	}
+	var results [2]discoveryResponse
+	var errs [2]error
+	var wg sync.WaitGroup
+	for i, ctxName := range []string{leftCtx, rightCtx} {
+		wg.Add(1)
+		go func(i int, ctxName string) {
+			defer wg.Done()
+			results[i], errs[i] = cm.discover(ctxName)
+		}(i, ctxName)
+	}
+	wg.Wait()
+	for i, ctxName := range []string{leftCtx, rightCtx} {
+		if errs[i] != nil {
+			http.Error(w, fmt.Sprintf("discovery for %s: %v", ctxName, errs[i]), http.StatusBadGateway)
+			return
+		}
+	}
+
+	resp := discoveryDiffResponse{Left: leftCtx, Right: rightCtx, Verbs: []verbDifference{}}
+	resp.Versions = diffSets(results[0].APIVersions, results[1].APIVersions)
+	resp.Groups = diffSets(apiGroups(results[0].APIVersions), apiGroups(results[1].APIVersions))
+
+	leftResources := resourcesByKey(results[0].APIResources)
+	rightResources := resourcesByKey(results[1].APIResources)
+	resp.Resources = diffSets(mapKeys(leftResources), mapKeys(rightResources))
+	for _, key := range mapKeys(leftResources) {
+		right, ok := rightResources[key]
+		if !ok {
+			continue
+		}
+		left := leftResources[key]
+		verbs := diffSets(left.Verbs, right.Verbs)
+		if len(verbs.OnlyLeft)+len(verbs.OnlyRight) > 0 {
+			resp.Verbs = append(resp.Verbs, verbDifference{
+				APIVersion: left.APIVersion,
+				Resource:   left.Name,
+				OnlyLeft:   verbs.OnlyLeft,
+				OnlyRight:  verbs.OnlyRight,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// diffSets returns the sorted entries of left missing from right and the
+// other way round.
+func diffSets(left, right []string) setDifference {
+	inLeft := make(map[string]bool, len(left))
+	for _, v := range left {
+		inLeft[v] = true
+	}
+	inRight := make(map[string]bool, len(right))
+	for _, v := range right {
+		inRight[v] = true
+	}
+	diff := setDifference{OnlyLeft: []string{}, OnlyRight: []string{}}
+	for v := range inLeft {
+		if !inRight[v] {
+			diff.OnlyLeft = append(diff.OnlyLeft, v)
+		}
+	}
+	for v := range inRight {
+		if !inLeft[v] {
+			diff.OnlyRight = append(diff.OnlyRight, v)
+		}
+	}
+	sort.Strings(diff.OnlyLeft)
+	sort.Strings(diff.OnlyRight)
+	return diff
+}
+
+// apiGroups returns the group names of group versions, "core" for the
+// legacy v1 group.
+func apiGroups(versions []string) []string {
+	groups := make([]string, 0, len(versions))
+	for _, version := range versions {
+		gv, err := schema.ParseGroupVersion(version)
+		if err != nil {
+			continue
+		}
+		if gv.Group == "" {
+			groups = append(groups, "core")
+		} else {
+			groups = append(groups, gv.Group)
+		}
+	}
+	return groups
+}
+
+func resourcesByKey(resources []discoveryResource) map[string]discoveryResource {
+	byKey := make(map[string]discoveryResource, len(resources))
+	for _, resource := range resources {
+		byKey[resource.APIVersion+"/"+resource.Name] = resource
+	}
+	return byKey
+}
+
+func mapKeys(m map[string]discoveryResource) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDiffValues(t *testing.T) {
+	tests := []struct {
+		name        string
+		left, right interface{}
+		want        []fieldDifference
+	}{
+		{
+			name:  "equal",
+			left:  map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(1)}},
+			right: map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(1)}},
+		},
+		{
+			name:  "changed scalar",
+			left:  map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(1)}},
+			right: map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(2)}},
+			want:  []fieldDifference{{Path: "spec.replicas", Left: int64(1), Right: int64(2)}},
+		},
+		{
+			name:  "keys on one side, sorted",
+			left:  map[string]interface{}{"b": "x"},
+			right: map[string]interface{}{"a": "y"},
+			want: []fieldDifference{
+				{Path: "a", Right: "y"},
+				{Path: "b", Left: "x"},
+			},
+		},
+		{
+			name:  "lists of different length",
+			left:  map[string]interface{}{"items": []interface{}{"a"}},
+			right: map[string]interface{}{"items": []interface{}{"a", "b"}},
+			want:  []fieldDifference{{Path: "items[1]", Right: "b"}},
+		},
+		{
+			name:  "list elements are compared by index",
+			left:  []interface{}{map[string]interface{}{"name": "eth0"}},
+			right: []interface{}{map[string]interface{}{"name": "eth1"}},
+			want:  []fieldDifference{{Path: "[0].name", Left: "eth0", Right: "eth1"}},
+		},
+		{
+			name:  "keys with dots are quoted",
+			left:  map[string]interface{}{"labels": map[string]interface{}{"app.kubernetes.io/name": "a"}},
+			right: map[string]interface{}{"labels": map[string]interface{}{"app.kubernetes.io/name": "b"}},
+			want:  []fieldDifference{{Path: `labels["app.kubernetes.io/name"]`, Left: "a", Right: "b"}},
+		},
+		{
+			name:  "type change is reported at the parent",
+			left:  map[string]interface{}{"spec": map[string]interface{}{"a": "x"}},
+			right: map[string]interface{}{"spec": "x"},
+			want:  []fieldDifference{{Path: "spec", Left: map[string]interface{}{"a": "x"}, Right: "x"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffValues("", tt.left, tt.right, nil)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffValues() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeForDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]interface{}
+		want     map[string]interface{}
+	}{
+		{
+			name: "server fields are dropped",
+			metadata: map[string]interface{}{
+				"name":              "vm",
+				"namespace":         "default",
+				"uid":               "1234",
+				"resourceVersion":   "42",
+				"generation":        int64(3),
+				"selfLink":          "/apis/kubevirt.io/v1/namespaces/default/virtualmachines/vm",
+				"creationTimestamp": "2024-01-01T00:00:00Z",
+				"managedFields":     []interface{}{map[string]interface{}{"manager": "kubectl"}},
+				"ownerReferences":   []interface{}{map[string]interface{}{"name": "owner"}},
+				"annotations": map[string]interface{}{
+					"kubectl.kubernetes.io/last-applied-configuration": "{}",
+				},
+			},
+			want: map[string]interface{}{"name": "vm", "namespace": "default"},
+		},
+		{
+			name: "other annotations are kept",
+			metadata: map[string]interface{}{
+				"name": "vm",
+				"annotations": map[string]interface{}{
+					"kubectl.kubernetes.io/last-applied-configuration": "{}",
+					"owner": "team-a",
+				},
+			},
+			want: map[string]interface{}{"name": "vm", "annotations": map[string]interface{}{"owner": "team-a"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "kubevirt.io/v1",
+				"kind":       "VirtualMachine",
+				"metadata":   tt.metadata,
+				"spec":       map[string]interface{}{"runStrategy": "Always"},
+				"status":     map[string]interface{}{"ready": true},
+			}}
+			normalizeForDiff(obj)
+			want := map[string]interface{}{
+				"apiVersion": "kubevirt.io/v1",
+				"kind":       "VirtualMachine",
+				"metadata":   tt.want,
+				"spec":       map[string]interface{}{"runStrategy": "Always"},
+			}
+			if !reflect.DeepEqual(obj.Object, want) {
+				t.Errorf("normalizeForDiff() = %#v, want %#v", obj.Object, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	dashboardURL       string
+	escapeSequence     string
+	insecureSkipVerify bool
+)
+
+var errDetached = errors.New("detached")
+
+var consoleCmd = &cobra.Command{
+	Use:   "console <namespace>/<vmi>",
+	Short: "Open a VMI serial console through a running dashboard",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ns, vmi, err := parseVMIRef(args[0])
+		if err != nil {
+			return err
+		}
+		escape, err := parseEscapeSequence(escapeSequence)
+		if err != nil {
+			return err
+		}
+		return runConsole(ns, vmi, escape)
+	},
+}
+
+func init() {
+	addDashboardClientFlags(consoleCmd)
+	consoleCmd.Flags().StringVar(&escapeSequence, "escape", "^]", "escape character used to detach from the console")
+	rootCmd.AddCommand(consoleCmd)
+}
+
+// addDashboardClientFlags registers the flags shared by subcommands that talk
+// to a running dashboard instead of the Kubernetes API.
+func addDashboardClientFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&dashboardURL, "server", "http://127.0.0.1:11111", "URL of the running dashboard")
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "namespace of the VMI when not given as <namespace>/<vmi> (default \"default\")")
+	cmd.Flags().StringVar(&contextName, "context", "", "the dashboard context to use (defaults to the dashboard's default)")
+	cmd.Flags().BoolVar(&insecureSkipVerify, "insecure-skip-tls-verify", false, "skip TLS certificate verification for an https dashboard")
+}
+
+func parseVMIRef(ref string) (string, string, error) {
+	ns, name := namespace, ref
+	if ns == "" {
+		ns = metav1.NamespaceDefault
+	}
+	if i := strings.Index(ref, "/"); i >= 0 {
+		ns, name = ref[:i], ref[i+1:]
+	}
+	if ns == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("invalid VMI reference %q, expected <namespace>/<vmi>", ref)
+	}
+	return ns, name, nil
+}
+
+// parseEscapeSequence accepts either a single character or caret notation
+// such as "^]" and returns the byte that detaches the session.
+func parseEscapeSequence(s string) (byte, error) {
+	switch {
+	case len(s) == 1:
+		return s[0], nil
+	case len(s) == 2 && s[0] == '^':
+		return s[1] & 0x1f, nil
+	}
+	return 0, fmt.Errorf("invalid escape sequence %q, expected a single character or ^X", s)
+}
+
+// dialDashboard opens a websocket to the given dashboard API path.
+func dialDashboard(path string, query url.Values) (*websocket.Conn, error) {
+	u, err := url.Parse(dashboardURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dashboard URL %q: %v", dashboardURL, err)
+	}
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("unsupported dashboard URL scheme %q", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	if contextName != "" {
+		query.Set("context", contextName)
+	}
+	u.RawQuery = query.Encode()
+
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = []string{"binary"}
+	if insecureSkipVerify {
+		dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	conn, resp, err := dialer.Dial(u.String(), nil)
+	if err != nil {
+		if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+			return nil, fmt.Errorf("connect to %s: %s", u.Redacted(), resp.Status)
+		}
+		return nil, fmt.Errorf("connect to %s: %v", u.Redacted(), err)
+	}
+	return conn, nil
+}
+
+func runConsole(ns, vmi string, escape byte) error {
+	conn, err := dialDashboard("/api/v1/ws", url.Values{
+		"namespace": {ns},
+		"vmi":       {vmi},
+		"type":      {"serial"},
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The dashboard answers with a text frame: either the ready notice or the
+	// error that prevented the console from being opened.
+	_, payload, err := conn.ReadMessage()
+	if err != nil {
+		return fmt.Errorf("waiting for console: %v", err)
+	}
+	if msg := string(payload); strings.HasPrefix(msg, "console error") {
+		return errors.New(msg)
+	}
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return fmt.Errorf("make terminal raw: %v", err)
+		}
+		defer term.Restore(fd, state)
+	}
+	fmt.Fprintf(os.Stderr, "Successfully connected to %s/%s console. The escape sequence is %s\r\n", ns, vmi, escapeSequence)
+
+	done := make(chan error, 2)
+
+	go func() {
+		for {
+			messageType, payload, err := conn.ReadMessage()
+			if err != nil {
+				done <- err
+				return
+			}
+			if messageType == websocket.BinaryMessage {
+				os.Stdout.Write(payload)
+			} else {
+				fmt.Fprintf(os.Stderr, "\r\n%s\r\n", payload)
+			}
+		}
+	}()
+
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			n, err := os.Stdin.Read(buffer)
+			if n > 0 {
+				data := buffer[:n]
+				i := bytes.IndexByte(data, escape)
+				if i >= 0 {
+					data = data[:i]
+				}
+				if len(data) > 0 {
+					if sendErr := conn.WriteMessage(websocket.BinaryMessage, data); sendErr != nil {
+						done <- sendErr
+						return
+					}
+				}
+				if i >= 0 {
+					done <- errDetached
+					return
+				}
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	err = <-done
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	fmt.Fprint(os.Stderr, "\r\n")
+	if errors.Is(err, errDetached) || errors.Is(err, io.EOF) || websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestParseEscapeSequence(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    byte
+		wantErr bool
+	}{
+		{in: "^]", want: 0x1d},
+		{in: "^[", want: 0x1b},
+		{in: "^a", want: 0x01},
+		{in: "^A", want: 0x01},
+		{in: "^^", want: 0x1e},
+		{in: "q", want: 'q'},
+		{in: "^", want: '^'},
+		{in: "", wantErr: true},
+		{in: "ab", wantErr: true},
+		{in: "^ab", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseEscapeSequence(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseEscapeSequence(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("parseEscapeSequence(%q) = %#x, want %#x", tt.in, got, tt.want)
+		}
+	}
+}
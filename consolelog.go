@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kvv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+)
+
+const (
+	guestConsoleLogContainer = "guest-console-log"
+	consoleLogDefaultLimit   = 1000
+	// consoleLogMaxBytes caps how much of the requested tail of a launcher
+	// log is read per request.
+	consoleLogMaxBytes int64 = 16 << 20
+	// consoleLogMaxLineBytes truncates single serial lines, a guest may print
+	// megabytes without a newline.
+	consoleLogMaxLineBytes = 1 << 20
+)
+
+type consoleLogPod struct {
+	Name              string      `json:"name"`
+	CreationTimestamp metav1.Time `json:"creationTimestamp"`
+	Phase             string      `json:"phase"`
+	Current           bool        `json:"current"`
+	Restarts          int32       `json:"restarts"`
+}
+
+type consoleLogResponse struct {
+	Pod      string          `json:"pod"`
+	Previous bool            `json:"previous"`
+	Pods     []consoleLogPod `json:"pods"`
+	Lines    []string        `json:"lines"`
+	Offset   int             `json:"offset"`
+	HasMore  bool            `json:"hasMore"`
+}
+
+// handleConsoleLog returns persisted guest serial output from the
+// guest-console-log container of a VMI's virt-launcher pods. Pages are counted
+// from the end of the log: offset=0 returns the newest limit lines.
+// source=prior-pod reads the launcher pod before the current one, after a
+// restart or migration; previous=true reads the previous instance of the
+// guest-console-log container within the selected pod.
+func handleConsoleLog(client kubecli.KubevirtClient, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	ns, vmiName := q.Get("namespace"), q.Get("vmi")
+	if ns == "" || vmiName == "" {
+		http.Error(w, "missing namespace or vmi", http.StatusBadRequest)
+		return
+	}
+	limit := consoleLogDefaultLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	offset := 0
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+	previous := q.Get("previous") == "true"
+	priorPod := false
+	switch source := q.Get("source"); source {
+	case "", "current":
+	case "prior-pod":
+		priorPod = true
+	default:
+		http.Error(w, fmt.Sprintf("invalid source %q, expected current or prior-pod", source), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	pods, err := launcherPods(ctx, client, ns, vmiName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(pods) == 0 {
+		http.Error(w, fmt.Sprintf("no virt-launcher pod found for %s/%s", ns, vmiName), http.StatusNotFound)
+		return
+	}
+
+	// Without an explicit pod, read the current launcher, or the newest one
+	// when the VMI is gone. source=prior-pod picks the launcher before it.
+	podName := q.Get("pod")
+	if podName == "" {
+		idx := 0
+		for i, pod := range pods {
+			if pod.Current {
+				idx = i
+				break
+			}
+		}
+		if priorPod {
+			idx++
+			if idx >= len(pods) {
+				http.Error(w, "no prior virt-launcher pod available", http.StatusNotFound)
+				return
+			}
+		}
+		podName = pods[idx].Name
+	}
+
+	// One line beyond the page tells whether older lines exist.
+	lines, err := readConsoleLog(ctx, client, ns, podName, previous, int64(offset+limit+1))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if apierrors.IsNotFound(err) || apierrors.IsBadRequest(err) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	end := max(len(lines)-offset, 0)
+	start := max(end-limit, 0)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(consoleLogResponse{
+		Pod:      podName,
+		Previous: previous,
+		Pods:     pods,
+		Lines:    lines[start:end],
+		Offset:   offset,
+		HasMore:  start > 0,
+	})
+}
+
+// launcherPods lists the virt-launcher pods of a VMI, newest first. Pods that
+// belong to the running VMI instance are marked as current.
+func launcherPods(ctx context.Context, client kubecli.KubevirtClient, ns, vmiName string) ([]consoleLogPod, error) {
+	selector := fmt.Sprintf("%s=virt-launcher,%s=%s", kvv1.AppLabel, kvv1.VirtualMachineNameLabel, vmiName)
+	list, err := client.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	var vmiUID string
+	if vmi, err := client.VirtualMachineInstance(ns).Get(ctx, vmiName, metav1.GetOptions{}); err == nil {
+		vmiUID = string(vmi.UID)
+	}
+
+	pods := make([]consoleLogPod, 0, len(list.Items))
+	for _, pod := range list.Items {
+		if !hasContainer(pod, guestConsoleLogContainer) {
+			continue
+		}
+		var restarts int32
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == guestConsoleLogContainer {
+				restarts = status.RestartCount
+			}
+		}
+		pods = append(pods, consoleLogPod{
+			Name:              pod.Name,
+			CreationTimestamp: pod.CreationTimestamp,
+			Phase:             string(pod.Status.Phase),
+			Current:           vmiUID != "" && pod.Labels[kvv1.CreatedByLabel] == vmiUID && pod.DeletionTimestamp == nil,
+			Restarts:          restarts,
+		})
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].CreationTimestamp.After(pods[j].CreationTimestamp.Time) })
+	return pods, nil
+}
+
+func hasContainer(pod corev1.Pod, name string) bool {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readConsoleLog reads the last tailLines lines of a launcher's guest console
+// log, at most consoleLogMaxBytes of them. Lines longer than
+// consoleLogMaxLineBytes are truncated.
+func readConsoleLog(ctx context.Context, client kubecli.KubevirtClient, ns, pod string, previous bool, tailLines int64) ([]string, error) {
+	limitBytes := consoleLogMaxBytes
+	stream, err := client.CoreV1().Pods(ns).GetLogs(pod, &corev1.PodLogOptions{
+		Container:  guestConsoleLogContainer,
+		Previous:   previous,
+		TailLines:  &tailLines,
+		LimitBytes: &limitBytes,
+	}).Stream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	lines := make([]string, 0)
+	reader := bufio.NewReaderSize(stream, 64*1024)
+	var line []byte
+	for {
+		chunk, isPrefix, err := reader.ReadLine()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if room := consoleLogMaxLineBytes - len(line); room > 0 {
+			line = append(line, chunk[:min(len(chunk), room)]...)
+		}
+		if !isPrefix {
+			lines = append(lines, strings.TrimRight(string(line), "\r"))
+			line = line[:0]
+		}
+	}
+}
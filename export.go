@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kvv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+)
+
+const (
+	// exportFlushInterval is how many rows are written between flushes.
+	exportFlushInterval = 100
+	// exportPVCPageSize is how many PVCs are listed per request while looking
+	// up disk sizes.
+	exportPVCPageSize = 500
+)
+
+var inventoryColumns = []string{
+	"cluster", "namespace", "name", "status", "node", "cpu", "memory", "disks", "networks", "ips", "instanceType", "labels", "created",
+}
+
+// inventoryRow is one VM of an inventory export.
+type inventoryRow struct {
+	Cluster      string            `json:"cluster"`
+	Namespace    string            `json:"namespace"`
+	Name         string            `json:"name"`
+	Status       string            `json:"status"`
+	Node         string            `json:"node,omitempty"`
+	CPU          int64             `json:"cpu"`
+	Memory       string            `json:"memory,omitempty"`
+	Disks        []inventoryDisk   `json:"disks"`
+	Networks     []string          `json:"networks"`
+	IPs          []string          `json:"ips"`
+	InstanceType string            `json:"instanceType,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Created      time.Time         `json:"created"`
+}
+
+type inventoryDisk struct {
+	Name string `json:"name"`
+	// Source is the backing object, e.g. pvc/root or containerDisk/image.
+	Source string `json:"source"`
+	Size   string `json:"size,omitempty"`
+}
+
+// handleVMExport streams the VMs selected like /api/v1/vms as an inventory,
+// as CSV (format=csv, the default) or JSON lines (format=jsonl). Disk sizes
+// come from the PVCs, or from the DataVolume templates of VMs whose disks
+// are not provisioned yet or whose PVCs cannot be listed. limit and continue
+// are rejected. A connection that ends without the final chunk was truncated
+// by a failed write.
+func (cm *ClusterManager) handleVMExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "jsonl" {
+		http.Error(w, fmt.Sprintf("invalid format %q, use csv or jsonl", format), http.StatusBadRequest)
+		return
+	}
+	// An inventory always covers every matching VM, a page of it would pass
+	// for the whole.
+	if r.URL.Query().Has("limit") || r.URL.Query().Has("continue") {
+		http.Error(w, "limit and continue are not supported, the export contains every matching VM", http.StatusBadRequest)
+		return
+	}
+	ctxName := cm.contextNameForRequest(r)
+	opts, err := parseVMListOptions(r.URL.Query(), cm.defaultNamespace(ctxName))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	client, _, _, err := cm.clientsFor(ctxName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	vmCache, err := cm.vmCacheFor(ctxName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	items, vmis, _, err := listVMsAndVMIs(r.Context(), client, vmCache, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	selected, _, _ := selectVMs(items, vmis, opts)
+	// Without PVCs the export falls back to the DataVolume template sizes.
+	claimSizes, err := lookupClaimSizes(r.Context(), client, opts.namespace, selected)
+	if err != nil {
+		log.Printf("VM export for context %s: listing PVCs failed: %v", ctxName, err)
+	}
+
+	filename := fmt.Sprintf("vms-%s-%s.%s", ctxName, time.Now().UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	flusher, _ := w.(http.Flusher)
+	var writeRow func(row inventoryRow) error
+	var flush func() error
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+		writeRow = func(row inventoryRow) error { return cw.Write(row.csvRecord()) }
+		// The header is buffered, a failure surfaces with the first flush.
+		_ = cw.Write(inventoryColumns)
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		flush = func() error { return nil }
+		writeRow = func(row inventoryRow) error { return enc.Encode(row) }
+	}
+	// Once rows are written the status can no longer change: failures abort
+	// the connection so clients do not take the partial inventory for a
+	// complete one, and instrument counts the request as aborted.
+	for i := range selected {
+		if err := writeRow(inventoryRowFor(ctxName, &selected[i], vmis, claimSizes)); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+		if (i+1)%exportFlushInterval == 0 {
+			if err := flush(); err != nil {
+				panic(http.ErrAbortHandler)
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// lookupClaimSizes returns the sizes of the PVCs the VMs use, keyed by
+// namespace/name. PVCs are listed in pages and only the referenced ones are
+// kept; sizes found before an error are returned with it.
+func lookupClaimSizes(ctx context.Context, client kubecli.KubevirtClient, ns string, vms []kvv1.VirtualMachine) (map[string]string, error) {
+	claimSizes := make(map[string]string)
+	wanted := make(map[string]bool)
+	for i := range vms {
+		if vms[i].Spec.Template == nil {
+			continue
+		}
+		for _, volume := range vms[i].Spec.Template.Spec.Volumes {
+			switch {
+			case volume.DataVolume != nil:
+				wanted[vms[i].Namespace+"/"+volume.DataVolume.Name] = true
+			case volume.PersistentVolumeClaim != nil:
+				wanted[vms[i].Namespace+"/"+volume.PersistentVolumeClaim.ClaimName] = true
+			}
+		}
+	}
+	if len(wanted) == 0 {
+		return claimSizes, nil
+	}
+	listOptions := metav1.ListOptions{Limit: exportPVCPageSize}
+	for {
+		pvcs, err := client.CoreV1().PersistentVolumeClaims(ns).List(ctx, listOptions)
+		if err != nil {
+			return claimSizes, err
+		}
+		for _, pvc := range pvcs.Items {
+			key := pvc.Namespace + "/" + pvc.Name
+			if !wanted[key] {
+				continue
+			}
+			size, ok := pvc.Status.Capacity[corev1.ResourceStorage]
+			if !ok {
+				size, ok = pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+			}
+			if ok {
+				claimSizes[key] = size.String()
+			}
+		}
+		if pvcs.Continue == "" {
+			return claimSizes, nil
+		}
+		listOptions.Continue = pvcs.Continue
+	}
+}
+
+func inventoryRowFor(cluster string, vm *kvv1.VirtualMachine, vmis vmiLookup, claimSizes map[string]string) inventoryRow {
+	summary := summarizeVM(vm, vmis, noPods)
+	row := inventoryRow{
+		Cluster:      cluster,
+		Namespace:    vm.Namespace,
+		Name:         vm.Name,
+		Status:       summary.Status,
+		Node:         summary.Node,
+		CPU:          summary.CPU,
+		Disks:        []inventoryDisk{},
+		Networks:     []string{},
+		IPs:          summary.IPs,
+		InstanceType: summary.InstanceType,
+		Labels:       vm.Labels,
+		Created:      vm.CreationTimestamp.Time,
+	}
+	if row.IPs == nil {
+		row.IPs = []string{}
+	}
+	if summary.MemoryBytes > 0 {
+		row.Memory = resource.NewQuantity(summary.MemoryBytes, resource.BinarySI).String()
+	}
+	if vm.Spec.Template == nil {
+		return row
+	}
+
+	templateSizes := make(map[string]string)
+	for _, dvt := range vm.Spec.DataVolumeTemplates {
+		if dvt.Spec.Storage != nil {
+			if size, ok := dvt.Spec.Storage.Resources.Requests[corev1.ResourceStorage]; ok {
+				templateSizes[dvt.Name] = size.String()
+			}
+		} else if dvt.Spec.PVC != nil {
+			if size, ok := dvt.Spec.PVC.Resources.Requests[corev1.ResourceStorage]; ok {
+				templateSizes[dvt.Name] = size.String()
+			}
+		}
+	}
+	for _, volume := range vm.Spec.Template.Spec.Volumes {
+		disk := inventoryDisk{Name: volume.Name}
+		claim := ""
+		switch {
+		case volume.DataVolume != nil:
+			claim = volume.DataVolume.Name
+			disk.Source = "dv/" + claim
+		case volume.PersistentVolumeClaim != nil:
+			claim = volume.PersistentVolumeClaim.ClaimName
+			disk.Source = "pvc/" + claim
+		case volume.ContainerDisk != nil:
+			disk.Source = "containerDisk/" + volume.ContainerDisk.Image
+		case volume.CloudInitNoCloud != nil:
+			disk.Source = "cloudInitNoCloud"
+		case volume.CloudInitConfigDrive != nil:
+			disk.Source = "cloudInitConfigDrive"
+		case volume.ConfigMap != nil:
+			disk.Source = "configMap/" + volume.ConfigMap.Name
+		case volume.Secret != nil:
+			disk.Source = "secret/" + volume.Secret.SecretName
+		case volume.EmptyDisk != nil:
+			disk.Source = "emptyDisk"
+			disk.Size = volume.EmptyDisk.Capacity.String()
+		default:
+			disk.Source = "other"
+		}
+		if claim != "" {
+			disk.Size = claimSizes[vm.Namespace+"/"+claim]
+			if disk.Size == "" {
+				disk.Size = templateSizes[claim]
+			}
+		}
+		row.Disks = append(row.Disks, disk)
+	}
+	for _, network := range vm.Spec.Template.Spec.Networks {
+		switch {
+		case network.Pod != nil:
+			row.Networks = append(row.Networks, network.Name+"=pod")
+		case network.Multus != nil:
+			row.Networks = append(row.Networks, network.Name+"="+network.Multus.NetworkName)
+		default:
+			row.Networks = append(row.Networks, network.Name)
+		}
+	}
+	return row
+}
+
+// csvRecord flattens a row in the order of inventoryColumns; lists are
+// joined with "; ".
+func (row inventoryRow) csvRecord() []string {
+	disks := make([]string, 0, len(row.Disks))
+	for _, disk := range row.Disks {
+		entry := disk.Name + "=" + disk.Source
+		if disk.Size != "" {
+			entry += " (" + disk.Size + ")"
+		}
+		disks = append(disks, entry)
+	}
+	labels := make([]string, 0, len(row.Labels))
+	for key, value := range row.Labels {
+		labels = append(labels, key+"="+value)
+	}
+	sort.Strings(labels)
+	return []string{
+		row.Cluster,
+		row.Namespace,
+		row.Name,
+		row.Status,
+		row.Node,
+		fmt.Sprint(row.CPU),
+		row.Memory,
+		strings.Join(disks, "; "),
+		strings.Join(row.Networks, "; "),
+		strings.Join(row.IPs, "; "),
+		row.InstanceType,
+		strings.Join(labels, "; "),
+		row.Created.UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kvv1 "kubevirt.io/api/core/v1"
+)
+
+// fleetClusterTimeout is the default time each context gets to answer a
+// fleet request.
+const fleetClusterTimeout = 15 * time.Second
+
+// fleetVM is a VirtualMachine tagged with the context it was listed from.
+type fleetVM struct {
+	Cluster string `json:"cluster"`
+	kvv1.VirtualMachine
+}
+
+type fleetError struct {
+	Cluster string `json:"cluster"`
+	Error   string `json:"error"`
+}
+
+type fleetVMsResponse struct {
+	Items    []fleetVM    `json:"items"`
+	Clusters []string     `json:"clusters"`
+	Errors   []fleetError `json:"errors"`
+}
+
+// handleFleetVMs lists VMs across contexts concurrently. contexts selects a
+// comma separated subset (default all), timeout bounds each context, and
+// namespace, name, status and condition filter like /api/v1/vms. Contexts that fail or
+// time out are reported in errors while the others are still returned.
+func (cm *ClusterManager) handleFleetVMs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, err := parseVMFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	timeout := fleetClusterTimeout
+	if v := q.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid timeout", http.StatusBadRequest)
+			return
+		}
+		timeout = d
+	}
+
+	contexts, err := cm.selectContexts(q.Get("contexts"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := fleetVMsResponse{
+		Items:    make([]fleetVM, 0),
+		Clusters: contexts,
+		Errors:   make([]fleetError, 0),
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range contexts {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			targetNs := resolveNamespace(q.Get("namespace"), cm.defaultNamespace(name))
+			vms, err := cm.listClusterVMs(r.Context(), name, targetNs, timeout)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				resp.Errors = append(resp.Errors, fleetError{Cluster: name, Error: err.Error()})
+				return
+			}
+			for _, vm := range filterVMs(vms, filter) {
+				resp.Items = append(resp.Items, fleetVM{Cluster: name, VirtualMachine: vm})
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	sort.Slice(resp.Items, func(i, j int) bool {
+		return resp.Items[i].CreationTimestamp.After(resp.Items[j].CreationTimestamp.Time)
+	})
+	sort.Slice(resp.Errors, func(i, j int) bool { return resp.Errors[i].Cluster < resp.Errors[j].Cluster })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// selectContexts parses a comma separated list of context names, returning
+// every context for an empty list. A list of only separators selects nothing
+// and is rejected.
+func (cm *ClusterManager) selectContexts(list string) ([]string, error) {
+	contexts, _ := cm.contextList()
+	if list == "" {
+		return append(make([]string, 0, len(contexts)), contexts...), nil
+	}
+	known := make(map[string]bool, len(contexts))
+	for _, name := range contexts {
+		known[name] = true
+	}
+	selected := make([]string, 0)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("unknown context %q", name)
+		}
+		selected = append(selected, name)
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no contexts selected in %q", list)
+	}
+	return selected, nil
+}
+
+func (cm *ClusterManager) listClusterVMs(ctx context.Context, ctxName, ns string, timeout time.Duration) ([]kvv1.VirtualMachine, error) {
+	client, _, _, err := cm.clientsFor(ctxName)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	vms, err := client.VirtualMachine(ns).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("timed out after %s", timeout)
+		}
+		return nil, err
+	}
+	return vms.Items, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+var clusterHealthInterval = time.Minute
+
+// clusterHealthTimeout bounds a single context's health check.
+const clusterHealthTimeout = 10 * time.Second
+
+type componentStatus struct {
+	Installed bool   `json:"installed"`
+	Version   string `json:"version,omitempty"`
+	Phase     string `json:"phase,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type clusterHealth struct {
+	Name             string           `json:"name"`
+	Default          bool             `json:"default"`
+	Reachable        bool             `json:"reachable"`
+	LatencyMs        int64            `json:"latencyMs"`
+	ServerVersion    string           `json:"serverVersion,omitempty"`
+	KubeVirt         *componentStatus `json:"kubevirt,omitempty"`
+	CDI              *componentStatus `json:"cdi,omitempty"`
+	CredentialExpiry *time.Time       `json:"credentialExpiry,omitempty"`
+	Error            string           `json:"error,omitempty"`
+	CheckedAt        *time.Time       `json:"checkedAt,omitempty"`
+}
+
+// watchClusterHealth checks every context right away, then every interval
+// and whenever the contexts change, caching the results for /api/v1/clusters.
+func (cm *ClusterManager) watchClusterHealth(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	events, cancel := cm.subscribe()
+	defer cancel()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cm.refreshClusterHealth(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-events:
+		}
+	}
+}
+
+// refreshClusterHealth checks all contexts concurrently and replaces the
+// cached results, dropping contexts that no longer exist.
+func (cm *ClusterManager) refreshClusterHealth(ctx context.Context) {
+	contexts, _ := cm.contextList()
+	results := make(map[string]clusterHealth, len(contexts))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range contexts {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			health := cm.checkClusterHealth(ctx, name)
+			mu.Lock()
+			results[name] = health
+			mu.Unlock()
+		}(name)
+	}
+	wg.Wait()
+
+	cm.mu.Lock()
+	cm.health = results
+	cm.mu.Unlock()
+}
+
+func (cm *ClusterManager) checkClusterHealth(ctx context.Context, name string) clusterHealth {
+	now := time.Now()
+	health := clusterHealth{Name: name, CheckedAt: &now}
+
+	client, _, _, err := cm.clientsFor(name)
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	restConfig, err := cm.restConfigFor(name)
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	health.CredentialExpiry = credentialExpiry(restConfig)
+
+	ctx, cancel := context.WithTimeout(ctx, clusterHealthTimeout)
+	defer cancel()
+
+	start := time.Now()
+	body, err := client.DiscoveryClient().RESTClient().Get().AbsPath("/version").Do(ctx).Raw()
+	health.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	var version struct {
+		GitVersion string `json:"gitVersion"`
+	}
+	if err := json.Unmarshal(body, &version); err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	health.Reachable = true
+	health.ServerVersion = version.GitVersion
+
+	health.KubeVirt = &componentStatus{}
+	if list, err := client.KubeVirt(metav1.NamespaceAll).List(ctx, metav1.ListOptions{}); err != nil {
+		health.KubeVirt.Error = componentError(err)
+	} else if len(list.Items) > 0 {
+		kv := list.Items[0]
+		health.KubeVirt.Installed = true
+		health.KubeVirt.Version = kv.Status.ObservedKubeVirtVersion
+		health.KubeVirt.Phase = string(kv.Status.Phase)
+	}
+
+	health.CDI = &componentStatus{}
+	if list, err := client.CdiClient().CdiV1beta1().CDIs().List(ctx, metav1.ListOptions{}); err != nil {
+		health.CDI.Error = componentError(err)
+	} else if len(list.Items) > 0 {
+		cdi := list.Items[0]
+		health.CDI.Installed = true
+		health.CDI.Version = cdi.Status.ObservedVersion
+		health.CDI.Phase = string(cdi.Status.Phase)
+	}
+	return health
+}
+
+// componentError hides the error of a CRD that is simply not installed.
+func componentError(err error) string {
+	if apierrors.IsNotFound(err) {
+		return ""
+	}
+	return err.Error()
+}
+
+// credentialExpiry reports when the client certificate or bearer token of a
+// config expires. Token files are skipped since they are rotated in place,
+// as are credentials without an expiry such as exec plugins.
+func credentialExpiry(restConfig *rest.Config) *time.Time {
+	certData := restConfig.CertData
+	if len(certData) == 0 && restConfig.CertFile != "" {
+		certData, _ = os.ReadFile(restConfig.CertFile)
+	}
+	if block, _ := pem.Decode(certData); block != nil {
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			return &cert.NotAfter
+		}
+	}
+	if restConfig.BearerToken != "" && restConfig.BearerTokenFile == "" {
+		return tokenExpiry(restConfig.BearerToken)
+	}
+	return nil
+}
+
+// tokenExpiry reads the exp claim of a JWT without verifying it.
+func tokenExpiry(token string) *time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return nil
+	}
+	exp := time.Unix(claims.Exp, 0)
+	return &exp
+}
+
+// handleClusters lists the cached health of every context. refresh=true
+// runs the checks before answering.
+func (cm *ClusterManager) handleClusters(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("refresh") == "true" {
+		cm.refreshClusterHealth(r.Context())
+	}
+	contexts, defaultCtx := cm.contextList()
+
+	cm.mu.RLock()
+	items := make([]clusterHealth, 0, len(contexts))
+	for _, name := range contexts {
+		health, ok := cm.health[name]
+		if !ok {
+			// Not checked yet, e.g. a context that was just added.
+			health = clusterHealth{Name: name, Error: "not checked yet"}
+		}
+		health.Default = name == defaultCtx
+		items = append(items, health)
+	}
+	cm.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items":           items,
+		"intervalSeconds": int(clusterHealthInterval / time.Second),
+	})
+}
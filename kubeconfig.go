@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+var kubeconfigPollInterval = 5 * time.Second
+
+// contextsEvent is pushed to the UI whenever the set of contexts changes.
+type contextsEvent struct {
+	Contexts []string `json:"contexts"`
+	Default  string   `json:"default"`
+	Added    []string `json:"added,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+	Changed  []string `json:"changed,omitempty"`
+}
+
+// contextInfo describes a context for the UI.
+type contextInfo struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Server    string `json:"server,omitempty"`
+	User      string `json:"user,omitempty"`
+}
+
+// applyKubeconfig replaces the known contexts with those in config, drops
+// cached clients of contexts that were removed or whose cluster or
+// credentials changed, and notifies subscribers about the difference.
+func (cm *ClusterManager) applyKubeconfig(config *clientcmdapi.Config) {
+	fingerprints := make(map[string]string, len(config.Contexts))
+	infos := make(map[string]contextInfo, len(config.Contexts))
+	for name, kubeContext := range config.Contexts {
+		fingerprints[name] = contextFingerprint(config, name)
+		info := contextInfo{Name: name, Namespace: kubeContext.Namespace, User: kubeContext.AuthInfo}
+		if cluster, ok := config.Clusters[kubeContext.Cluster]; ok {
+			info.Server = cluster.Server
+		}
+		infos[name] = info
+	}
+
+	cm.mu.Lock()
+	var ev contextsEvent
+	for name, fp := range fingerprints {
+		old, ok := cm.fingerprints[name]
+		_, shadowed := cm.registered[name]
+		switch {
+		case !ok && shadowed:
+			// The context now connects to the kubeconfig's cluster instead
+			// of the registered one.
+			ev.Changed = append(ev.Changed, name)
+			cm.invalidateLocked(name)
+			cm.stopVMCacheLocked(name)
+		case !ok:
+			ev.Added = append(ev.Added, name)
+		case old != fp:
+			ev.Changed = append(ev.Changed, name)
+			cm.invalidateLocked(name)
+			// Rotated credentials keep the cache, its informers pick up the
+			// new clients when they list or watch again.
+			if cm.infos[name].Server != infos[name].Server {
+				cm.stopVMCacheLocked(name)
+			}
+		}
+	}
+	for name := range cm.fingerprints {
+		if _, ok := fingerprints[name]; !ok {
+			if _, registered := cm.registered[name]; registered {
+				ev.Changed = append(ev.Changed, name)
+			} else {
+				ev.Removed = append(ev.Removed, name)
+			}
+			cm.invalidateLocked(name)
+			cm.stopVMCacheLocked(name)
+		}
+	}
+	cm.fingerprints = fingerprints
+	cm.infos = infos
+	cm.rebuildContextsLocked()
+	cm.resolveDefaultLocked(config.CurrentContext)
+	ev.Contexts = append([]string(nil), cm.contexts...)
+	ev.Default = cm.defaultCtx
+	cm.mu.Unlock()
+
+	if len(ev.Added)+len(ev.Removed)+len(ev.Changed) > 0 {
+		sort.Strings(ev.Added)
+		sort.Strings(ev.Removed)
+		sort.Strings(ev.Changed)
+		cm.publish(ev)
+	}
+}
+
+// contextInfos describes every known context. The --namespace flag, when
+// set, overrides the namespace of all of them.
+func (cm *ClusterManager) contextInfos() []contextInfo {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	infos := make([]contextInfo, 0, len(cm.contexts))
+	for _, name := range cm.contexts {
+		infos = append(infos, cm.contextInfoLocked(name))
+	}
+	return infos
+}
+
+// defaultNamespace returns the namespace requests of a context default to,
+// or "" for all namespaces.
+func (cm *ClusterManager) defaultNamespace(ctxName string) string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.contextInfoLocked(ctxName).Namespace
+}
+
+// contextInfoLocked describes a context. cm.mu must be held.
+func (cm *ClusterManager) contextInfoLocked(name string) contextInfo {
+	info := contextInfo{Name: name}
+	if registered, ok := cm.registeredLocked(name); ok {
+		info.Server = registered.server
+	} else if kubeInfo, ok := cm.infos[name]; ok {
+		info = kubeInfo
+	} else if name == inClusterContext && cm.inCluster {
+		info.Namespace = serviceAccountNamespace()
+		if restConfig, err := rest.InClusterConfig(); err == nil {
+			info.Server = restConfig.Host
+		}
+	}
+	if namespace != "" {
+		info.Namespace = namespace
+	}
+	return info
+}
+
+// invalidateLocked forgets every cached client of a context so the next
+// request rebuilds them. The VM cache keeps running, callers stop it with
+// stopVMCacheLocked when the context is gone or points elsewhere. cm.mu must
+// be held for writing.
+func (cm *ClusterManager) invalidateLocked(ctxName string) {
+	delete(cm.configs, ctxName)
+	delete(cm.clients, ctxName)
+	delete(cm.dynamics, ctxName)
+	delete(cm.proxies, ctxName)
+	delete(cm.discoveries, ctxName)
+	delete(cm.built, ctxName)
+}
+
+// contextFingerprint hashes everything a context's clients are built from, so
+// a change to its cluster endpoint, CA or credentials is detected.
+func contextFingerprint(config *clientcmdapi.Config, name string) string {
+	kubeContext := config.Contexts[name]
+	data, _ := json.Marshal(struct {
+		Context  *clientcmdapi.Context
+		Cluster  *clientcmdapi.Cluster
+		AuthInfo *clientcmdapi.AuthInfo
+	}{kubeContext, config.Clusters[kubeContext.Cluster], config.AuthInfos[kubeContext.AuthInfo]})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// watchKubeconfig polls the kubeconfig files (the explicit path, every entry
+// of KUBECONFIG or ~/.kube/config) and reloads the contexts when any of them
+// changes. Polling also notices the symlink swaps used by mounted Secrets.
+func (cm *ClusterManager) watchKubeconfig(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	loadingRules := newLoadingRules()
+	last := kubeconfigState(loadingRules.GetLoadingPrecedence())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		state := kubeconfigState(loadingRules.GetLoadingPrecedence())
+		if state == last {
+			continue
+		}
+		config, err := loadingRules.Load()
+		if err != nil {
+			// Keep the previous contexts, the file may be mid-write.
+			log.Printf("Failed to reload kubeconfig: %v", err)
+			continue
+		}
+		last = state
+		log.Printf("Kubeconfig changed, reloading contexts")
+		cm.applyKubeconfig(config)
+	}
+}
+
+func kubeconfigState(paths []string) string {
+	state := ""
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			state += path + ":missing;"
+			continue
+		}
+		state += fmt.Sprintf("%s:%d:%d;", path, info.ModTime().UnixNano(), info.Size())
+	}
+	return state
+}
+
+// subscribe registers for context change notifications until cancel is called.
+func (cm *ClusterManager) subscribe() (<-chan contextsEvent, func()) {
+	ch := make(chan contextsEvent, 1)
+	cm.mu.Lock()
+	cm.subscribers[ch] = struct{}{}
+	cm.mu.Unlock()
+	return ch, func() {
+		cm.mu.Lock()
+		delete(cm.subscribers, ch)
+		cm.mu.Unlock()
+	}
+}
+
+func (cm *ClusterManager) publish(ev contextsEvent) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	for ch := range cm.subscribers {
+		// Events carry the full context list, so a slow subscriber only needs
+		// the latest one.
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func (cm *ClusterManager) handleContextEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	events, cancel := cm.subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-events:
+			if err := writeSSE(w, "contexts", ev); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeSSE writes one server-sent event with a JSON payload.
+func writeSSE(w http.ResponseWriter, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
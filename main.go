@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	_ "embed"
 	"encoding/json"
@@ -19,8 +18,10 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/discovery"
@@ -29,8 +30,8 @@ import (
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/client-go/tools/remotecommand"
-	kvv1 "kubevirt.io/api/core/v1"
 	"kubevirt.io/client-go/kubecli"
 	kvcorev1 "kubevirt.io/client-go/kubevirt/typed/core/v1"
 	"sigs.k8s.io/yaml"
@@ -41,18 +42,16 @@ var (
 	namespace   string
 	kubeconfig  string
 	contextName string
-	statusFile  = "vm-statuses.txt"
 )
 
+// inClusterContext names the context built from the pod's service account.
+const inClusterContext = "in-cluster"
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin:  func(r *http.Request) bool { return true },
 	Subprotocols: []string{"binary"},
 }
 
-var defaultStatuses = []string{
-	"all", "Running", "Stopped", "Starting", "Migrating", "Paused", "ErrorPvcNotFound", "DataVolumeError", "ImagePullBackOff", "CrashLoopBackOff", "Terminating",
-}
-
 type discoveryResource struct {
 	Name         string   `json:"name"`
 	ShortNames   []string `json:"shortNames,omitempty"`
@@ -71,53 +70,107 @@ type discoveryResponse struct {
 
 // ClusterManager handles multiple kubeconfig contexts
 type ClusterManager struct {
-	mu          sync.RWMutex
-	configs     map[string]*rest.Config
-	clients     map[string]kubecli.KubevirtClient
-	proxies     map[string]*httputil.ReverseProxy
-	dynamics    map[string]dynamic.Interface
-	discoveries map[string]discovery.DiscoveryInterface
-	contexts    []string
-	defaultCtx  string
+	mu           sync.RWMutex
+	configs      map[string]*rest.Config
+	clients      map[string]kubecli.KubevirtClient
+	proxies      map[string]*httputil.ReverseProxy
+	dynamics     map[string]dynamic.Interface
+	discoveries  map[string]discovery.DiscoveryInterface
+	contexts     []string
+	defaultCtx   string
+	fingerprints map[string]string
+	infos        map[string]contextInfo
+	inCluster    bool
+	registered   map[string]*registeredCluster
+	subscribers  map[chan contextsEvent]struct{}
+	health       map[string]clusterHealth
+	built        map[string]time.Time
+	migrations   map[string]*clusterMigration
+	vmCaches     map[string]*vmCache
 }
 
 func NewClusterManager() (*ClusterManager, error) {
 	cm := &ClusterManager{
-		configs:     make(map[string]*rest.Config),
-		clients:     make(map[string]kubecli.KubevirtClient),
-		proxies:     make(map[string]*httputil.ReverseProxy),
-		dynamics:    make(map[string]dynamic.Interface),
-		discoveries: make(map[string]discovery.DiscoveryInterface),
+		configs:      make(map[string]*rest.Config),
+		clients:      make(map[string]kubecli.KubevirtClient),
+		proxies:      make(map[string]*httputil.ReverseProxy),
+		dynamics:     make(map[string]dynamic.Interface),
+		discoveries:  make(map[string]discovery.DiscoveryInterface),
+		fingerprints: make(map[string]string),
+		registered:   make(map[string]*registeredCluster),
+		subscribers:  make(map[chan contextsEvent]struct{}),
+		built:        make(map[string]time.Time),
+		migrations:   make(map[string]*clusterMigration),
+		vmCaches:     make(map[string]*vmCache),
+	}
+
+	// Inside a pod the in-cluster context is always offered, next to any
+	// contexts from a mounted kubeconfig.
+	if _, err := rest.InClusterConfig(); err == nil {
+		cm.inCluster = true
+	}
+
+	config, err := newLoadingRules().Load()
+	if err != nil {
+		if !cm.inCluster {
+			return nil, fmt.Errorf("could not find kubeconfig or in-cluster config: %v", err)
+		}
+		log.Printf("Failed to load kubeconfig: %v. Using the in-cluster config only", err)
+		config = clientcmdapi.NewConfig()
 	}
 
+	cm.applyKubeconfig(config)
+	return cm, nil
+}
+
+// resolveDefaultLocked picks the default context: the --context flag, else
+// the in-cluster context when running in a pod, else the kubeconfig's
+// current-context. cm.mu must be held for writing.
+func (cm *ClusterManager) resolveDefaultLocked(currentContext string) {
+	switch {
+	case contextName != "":
+		cm.defaultCtx = contextName
+	case cm.inCluster:
+		cm.defaultCtx = inClusterContext
+	default:
+		cm.defaultCtx = currentContext
+	}
+}
+
+func newLoadingRules() *clientcmd.ClientConfigLoadingRules {
 	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
 	if kubeconfig != "" {
 		loadingRules.ExplicitPath = kubeconfig
 	}
+	return loadingRules
+}
 
-	config, err := loadingRules.Load()
-	if err != nil {
-		log.Printf("Failed to load kubeconfig: %v. Checking in-cluster config...", err)
-		restConfig, err := rest.InClusterConfig()
-		if err != nil {
-			return nil, fmt.Errorf("could not find kubeconfig or in-cluster config: %v", err)
-		}
-		cm.contexts = []string{"in-cluster"}
-		cm.defaultCtx = "in-cluster"
-		cm.configs["in-cluster"] = restConfig
-		return cm, nil
+// rebuildContextsLocked recomputes the sorted context list from the
+// kubeconfig, the in-cluster config and registered clusters. cm.mu must be
+// held for writing.
+func (cm *ClusterManager) rebuildContextsLocked() {
+	contexts := make([]string, 0, len(cm.fingerprints)+len(cm.registered)+1)
+	if _, ok := cm.fingerprints[inClusterContext]; cm.inCluster && !ok {
+		contexts = append(contexts, inClusterContext)
 	}
-
-	for name := range config.Contexts {
-		cm.contexts = append(cm.contexts, name)
+	for name := range cm.fingerprints {
+		contexts = append(contexts, name)
 	}
-	sort.Strings(cm.contexts)
-	cm.defaultCtx = config.CurrentContext
-	if contextName != "" {
-		cm.defaultCtx = contextName
+	for name := range cm.registered {
+		// Kubeconfig contexts shadow registered clusters of the same name.
+		if _, ok := cm.registeredLocked(name); ok {
+			contexts = append(contexts, name)
+		}
 	}
+	sort.Strings(contexts)
+	cm.contexts = contexts
+}
 
-	return cm, nil
+// contextList returns the known context names and the default context.
+func (cm *ClusterManager) contextList() ([]string, string) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return append([]string(nil), cm.contexts...), cm.defaultCtx
 }
 
 func (cm *ClusterManager) contextNameForRequest(r *http.Request) string {
@@ -126,48 +179,58 @@ func (cm *ClusterManager) contextNameForRequest(r *http.Request) string {
 		ctxName = r.URL.Query().Get("context")
 	}
 	if ctxName == "" {
+		cm.mu.RLock()
 		ctxName = cm.defaultCtx
+		cm.mu.RUnlock()
 	}
 	return ctxName
 }
 
 func (cm *ClusterManager) getClient(r *http.Request) (kubecli.KubevirtClient, dynamic.Interface, *httputil.ReverseProxy, error) {
-	ctxName := cm.contextNameForRequest(r)
+	return cm.clientsFor(cm.contextNameForRequest(r))
+}
+
+// clientsFor returns the cached clients of a context, building them on first use.
+func (cm *ClusterManager) clientsFor(ctxName string) (kubecli.KubevirtClient, dynamic.Interface, *httputil.ReverseProxy, error) {
 	cm.mu.RLock()
 	client, ok := cm.clients[ctxName]
 	dyn, ok2 := cm.dynamics[ctxName]
 	proxy, ok3 := cm.proxies[ctxName]
 	_, ok4 := cm.discoveries[ctxName]
+	expired := cm.expiredLocked(ctxName)
 	cm.mu.RUnlock()
 
-	if ok && ok2 && ok3 && ok4 {
+	if ok && ok2 && ok3 && ok4 && !expired {
 		return client, dyn, proxy, nil
 	}
 
 	cm.mu.Lock()
-	defer cm.mu.Unlock()
+	client, dyn, proxy, err := cm.buildClientsLocked(ctxName)
+	cm.mu.Unlock()
+	if err != nil {
+		clientInitFailures.WithLabelValues(cm.metricsContext(ctxName)).Inc()
+	}
+	return client, dyn, proxy, err
+}
 
+// buildClientsLocked returns the clients of a context, rebuilding them when
+// they expired. cm.mu must be held for writing.
+func (cm *ClusterManager) buildClientsLocked(ctxName string) (kubecli.KubevirtClient, dynamic.Interface, *httputil.ReverseProxy, error) {
+	if cm.expiredLocked(ctxName) {
+		log.Printf("Clients for context %s are older than %s, rebuilding", ctxName, clientTTL)
+		cm.invalidateLocked(ctxName)
+	}
 	if client, ok := cm.clients[ctxName]; ok {
 		return client, cm.dynamics[ctxName], cm.proxies[ctxName], nil
 	}
 
 	log.Printf("Initializing clients for context: %s", ctxName)
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-	if kubeconfig != "" {
-		loadingRules.ExplicitPath = kubeconfig
-	}
-	configOverrides := &clientcmd.ConfigOverrides{CurrentContext: ctxName}
-	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
-
-	restConfig, err := clientConfig.ClientConfig()
+	restConfig, err := cm.buildRESTConfigLocked(ctxName)
 	if err != nil {
-		if ctxName == "in-cluster" {
-			restConfig, err = rest.InClusterConfig()
-		}
-		if err != nil {
-			return nil, nil, nil, err
-		}
+		return nil, nil, nil, err
 	}
+	builtAt := time.Now()
+	restConfig.Wrap(cm.evictOnUnauthorized(ctxName, builtAt))
 
 	virtClient, err := kubecli.GetKubevirtClientFromRESTConfig(restConfig)
 	if err != nil {
@@ -185,21 +248,47 @@ func (cm *ClusterManager) getClient(r *http.Request) (kubecli.KubevirtClient, dy
 
 	target, _ := url.Parse(restConfig.Host)
 	transport, _ := rest.TransportFor(restConfig)
-	proxy = httputil.NewSingleHostReverseProxy(target)
+	proxy := httputil.NewSingleHostReverseProxy(target)
 	proxy.Transport = transport
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		proxyUpstreamErrors.WithLabelValues(ctxName).Inc()
+		log.Printf("Proxy error for context %s: %v", ctxName, err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
 
 	cm.configs[ctxName] = restConfig
 	cm.clients[ctxName] = virtClient
 	cm.dynamics[ctxName] = dynClient
 	cm.proxies[ctxName] = proxy
 	cm.discoveries[ctxName] = discoveryClient
+	cm.built[ctxName] = builtAt
 
 	return virtClient, dynClient, proxy, nil
 }
 
+// buildRESTConfigLocked resolves the REST config of a context from the
+// registered clusters or the kubeconfig. cm.mu must be held.
+func (cm *ClusterManager) buildRESTConfigLocked(ctxName string) (*rest.Config, error) {
+	if registered, ok := cm.registeredLocked(ctxName); ok {
+		return rest.CopyConfig(registered.config), nil
+	}
+
+	if _, ok := cm.fingerprints[ctxName]; ctxName == inClusterContext && !ok {
+		return rest.InClusterConfig()
+	}
+
+	loadingRules := newLoadingRules()
+	configOverrides := &clientcmd.ConfigOverrides{CurrentContext: ctxName}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
+	return clientConfig.ClientConfig()
+}
+
 func (cm *ClusterManager) getDiscovery(r *http.Request) (discovery.DiscoveryInterface, error) {
-	ctxName := cm.contextNameForRequest(r)
-	if _, _, _, err := cm.getClient(r); err != nil {
+	return cm.discoveryFor(cm.contextNameForRequest(r))
+}
+
+func (cm *ClusterManager) discoveryFor(ctxName string) (discovery.DiscoveryInterface, error) {
+	if _, _, _, err := cm.clientsFor(ctxName); err != nil {
 		return nil, err
 	}
 	cm.mu.RLock()
@@ -212,8 +301,11 @@ func (cm *ClusterManager) getDiscovery(r *http.Request) (discovery.DiscoveryInte
 }
 
 func (cm *ClusterManager) getRESTConfig(r *http.Request) (*rest.Config, error) {
-	ctxName := cm.contextNameForRequest(r)
-	if _, _, _, err := cm.getClient(r); err != nil {
+	return cm.restConfigFor(cm.contextNameForRequest(r))
+}
+
+func (cm *ClusterManager) restConfigFor(ctxName string) (*rest.Config, error) {
+	if _, _, _, err := cm.clientsFor(ctxName); err != nil {
 		return nil, err
 	}
 	cm.mu.RLock()
@@ -233,16 +325,36 @@ var rootCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		ensureStatusFile()
+		loadCtx, cancel := context.WithTimeout(cmd.Context(), 30*time.Second)
+		err = cm.loadRegisteredClusters(loadCtx)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to load registered clusters from %s: %v", clusterSecretNamespace, err)
+		}
+		go cm.watchKubeconfig(cmd.Context(), kubeconfigPollInterval)
+		go cm.watchClusterHealth(cmd.Context(), clusterHealthInterval)
+		go cm.reapIdleVMCaches(cmd.Context())
 		return runServer(cm, listenAddr)
 	},
 }
 
 func init() {
 	rootCmd.Flags().StringVar(&listenAddr, "listen", "127.0.0.1:11111", "address to serve the dashboard on")
-	rootCmd.Flags().StringVarP(&namespace, "namespace", "n", "", "default namespace (optional)")
+	rootCmd.Flags().StringVarP(&namespace, "namespace", "n", "", "default namespace for every context, overriding the kubeconfig context namespaces (optional)")
 	rootCmd.Flags().StringVar(&kubeconfig, "kubeconfig", "", "absolute path to the kubeconfig file")
-	rootCmd.Flags().StringVar(&contextName, "context", "", "the name of the kubeconfig context to use")
+	rootCmd.Flags().StringVar(&contextName, "context", "", "the default context (defaults to in-cluster inside a pod, otherwise the kubeconfig current-context)")
+	rootCmd.Flags().StringVar(&clusterSecretNamespace, "cluster-secret-namespace", clusterSecretNamespace, "namespace holding the Secrets of clusters registered through the API")
+	rootCmd.Flags().DurationVar(&kubeconfigPollInterval, "kubeconfig-poll-interval", kubeconfigPollInterval, "how often to check the kubeconfig files for changes (0 disables reloading)")
+	rootCmd.Flags().DurationVar(&clientTTL, "client-ttl", clientTTL, "rebuild a context's clients after this long to pick up rotated credentials (0 disables)")
+	rootCmd.Flags().DurationVar(&vmCacheIdleTimeout, "vm-cache-idle-timeout", vmCacheIdleTimeout, "stop a context's VM informers after this long without requests (0 disables the cache)")
+	rootCmd.Flags().DurationVar(&clusterHealthInterval, "cluster-health-interval", clusterHealthInterval, "how often to check the health of every context (0 disables the checks)")
+	rootCmd.Flags().StringSliceVar(&extraStatuses, "vm-statuses", nil, "extra VM statuses to offer in the status filter besides KubeVirt's")
+	rootCmd.Flags().StringVar(&statusesFile, "vm-statuses-file", "", "file with extra VM statuses, one per line, e.g. mounted from a ConfigMap")
+	rootCmd.Flags().DurationVar(&consoleLimits.ConnectTimeout, "console-connect-timeout", consoleLimits.ConnectTimeout, "how long to wait for a serial console to become available")
+	rootCmd.Flags().DurationVar(&consoleLimits.IdleTimeout, "console-idle-timeout", consoleLimits.IdleTimeout, "close console sessions without input for this long (0 disables)")
+	rootCmd.Flags().DurationVar(&consoleLimits.MaxDuration, "console-max-duration", consoleLimits.MaxDuration, "maximum length of a console session (0 disables)")
+	rootCmd.Flags().DurationVar(&consoleLimits.Warning, "console-warning", consoleLimits.Warning, "how long before a console limit is reached to warn the client")
+	rootCmd.Flags().DurationVar(&consoleLimits.KeepAlive, "console-keepalive", consoleLimits.KeepAlive, "interval between websocket pings to console clients (0 disables pings and the read deadline)")
 }
 
 func main() {
@@ -251,120 +363,73 @@ func main() {
 	}
 }
 
-func ensureStatusFile() {
-	if _, err := os.Stat(statusFile); os.IsNotExist(err) {
-		f, _ := os.Create(statusFile)
-		defer f.Close()
-		for _, s := range defaultStatuses {
-			f.WriteString(s + "\n")
-		}
-	}
-}
-
-func loadStatuses() []string {
-	f, err := os.Open(statusFile)
-	if err != nil {
-		return defaultStatuses
-	}
-	defer f.Close()
-	var statuses []string
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line != "" {
-			statuses = append(statuses, line)
-		}
-	}
-	return statuses
-}
-
 func runServer(cm *ClusterManager, addr string) error {
 	distFS, _ := fs.Sub(uiContent, "ui/dist")
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/api/v1/contexts", func(w http.ResponseWriter, r *http.Request) {
+		contexts, defaultCtx := cm.contextList()
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{"contexts": cm.contexts, "default": cm.defaultCtx})
+		json.NewEncoder(w).Encode(map[string]interface{}{"contexts": contexts, "default": defaultCtx, "items": cm.contextInfos()})
 	})
 
+	mux.HandleFunc("/api/v1/contexts/events", cm.handleContextEvents)
+	mux.HandleFunc("/api/v1/clusters", cm.handleClusters)
+	mux.HandleFunc("/api/v1/clients/refresh", cm.handleRefreshClients)
+	mux.HandleFunc("/api/v1/cluster-registrations", cm.handleClusterRegistrations)
+	mux.HandleFunc("/api/v1/cluster-registrations/", cm.handleClusterRegistration)
+
 	mux.HandleFunc("/api/v1/vms", func(w http.ResponseWriter, r *http.Request) {
-		virtClient, _, _, err := cm.getClient(r)
+		ctxName := cm.contextNameForRequest(r)
+		virtClient, _, _, err := cm.clientsFor(ctxName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		vmCache, err := cm.vmCacheFor(ctxName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		handleListVMs(virtClient, w, r)
+		handleListVMs(virtClient, vmCache, cm.defaultNamespace(ctxName), w, r)
 	})
 
+	mux.HandleFunc("/api/v1/vms/stream", cm.handleVMStream)
+	mux.HandleFunc("/api/v1/vms/export", cm.handleVMExport)
+	mux.HandleFunc("/api/v1/fleet/vms", cm.handleFleetVMs)
+	mux.HandleFunc("/api/v1/capacity", cm.handleCapacity)
+	mux.HandleFunc("/api/v1/cluster-migrations", cm.handleClusterMigrations)
+	mux.HandleFunc("/api/v1/cluster-migrations/", cm.handleClusterMigration)
 	mux.HandleFunc("/api/v1/vm-statuses", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(loadStatuses())
-	})
-
-	mux.HandleFunc("/api/v1/discovery", func(w http.ResponseWriter, r *http.Request) {
-		discoveryClient, err := cm.getDiscovery(r)
+		ctxName := cm.contextNameForRequest(r)
+		virtClient, _, _, err := cm.clientsFor(ctxName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		groups, resourceLists, err := discoveryClient.ServerGroupsAndResources()
-		if err != nil && len(resourceLists) == 0 {
+		vmCache, err := cm.vmCacheFor(ctxName)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		handleVMStatuses(virtClient, vmCache, cm.defaultNamespace(ctxName), w, r)
+	})
 
-		versionSet := make(map[string]bool)
-		for _, group := range groups {
-			if group == nil {
-				continue
-			}
-			for _, version := range group.Versions {
-				if version.GroupVersion != "" {
-					versionSet[version.GroupVersion] = true
-				}
-			}
-		}
-
-		var resources []discoveryResource
-		for _, resourceList := range resourceLists {
-			if resourceList == nil {
-				continue
-			}
-			if resourceList.GroupVersion != "" {
-				versionSet[resourceList.GroupVersion] = true
-			}
-			for _, resource := range resourceList.APIResources {
-				if strings.Contains(resource.Name, "/") {
-					continue
-				}
-				resources = append(resources, discoveryResource{
-					Name:         resource.Name,
-					ShortNames:   resource.ShortNames,
-					APIVersion:   resourceList.GroupVersion,
-					Namespaced:   resource.Namespaced,
-					Kind:         resource.Kind,
-					Verbs:        resource.Verbs,
-					Categories:   resource.Categories,
-					SingularName: resource.SingularName,
-				})
-			}
-		}
+	mux.HandleFunc("/api/v1/console-settings", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(consoleLimits.settings())
+	})
 
-		versions := make([]string, 0, len(versionSet))
-		for version := range versionSet {
-			versions = append(versions, version)
+	mux.HandleFunc("/api/v1/discovery", func(w http.ResponseWriter, r *http.Request) {
+		resp, err := cm.discover(cm.contextNameForRequest(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		sort.Strings(versions)
-		sort.Slice(resources, func(i, j int) bool {
-			if resources[i].APIVersion == resources[j].APIVersion {
-				return resources[i].Name < resources[j].Name
-			}
-			return resources[i].APIVersion < resources[j].APIVersion
-		})
-
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(discoveryResponse{APIVersions: versions, APIResources: resources})
+		json.NewEncoder(w).Encode(resp)
 	})
+	mux.HandleFunc("/api/v1/discovery/diff", cm.handleDiscoveryDiff)
 
 	mux.HandleFunc("/api/v1/ws", func(w http.ResponseWriter, r *http.Request) {
 		virtClient, _, _, err := cm.getClient(r)
@@ -375,6 +440,15 @@ func runServer(cm *ClusterManager, addr string) error {
 		handleWebsocket(virtClient, w, r)
 	})
 
+	mux.HandleFunc("/api/v1/console-log", func(w http.ResponseWriter, r *http.Request) {
+		virtClient, _, _, err := cm.getClient(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		handleConsoleLog(virtClient, w, r)
+	})
+
 	mux.HandleFunc("/api/v1/pod-exec", func(w http.ResponseWriter, r *http.Request) {
 		restConfig, err := cm.getRESTConfig(r)
 		if err != nil {
@@ -384,6 +458,7 @@ func runServer(cm *ClusterManager, addr string) error {
 		handlePodExec(restConfig, w, r)
 	})
 
+	mux.HandleFunc("/api/v1/diff", cm.handleObjectDiff)
 	mux.HandleFunc("/api/v1/yaml/", func(w http.ResponseWriter, r *http.Request) {
 		_, dynClient, _, err := cm.getClient(r)
 		if err != nil {
@@ -413,10 +488,7 @@ func runServer(cm *ClusterManager, addr string) error {
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
-		obj.SetManagedFields(nil)
-		obj.SetResourceVersion("")
-		obj.SetGeneration(0)
-		obj.SetUID("")
+		stripServerFields(obj)
 		raw := obj.UnstructuredContent()
 		raw["apiVersion"] = apiVersion
 		raw["kind"] = kind
@@ -426,12 +498,21 @@ func runServer(cm *ClusterManager, addr string) error {
 	})
 
 	mux.HandleFunc("/api/v1/namespaces-list", func(w http.ResponseWriter, r *http.Request) {
-		virtClient, _, _, err := cm.getClient(r)
+		ctxName := cm.contextNameForRequest(r)
+		virtClient, _, _, err := cm.clientsFor(ctxName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		namespaces, err := virtClient.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+		if apierrors.IsForbidden(err) {
+			// Users limited to their own namespace still get the context's one.
+			if defaultNs := cm.defaultNamespace(ctxName); defaultNs != "" {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode([]string{defaultNs})
+				return
+			}
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -463,6 +544,10 @@ func runServer(cm *ClusterManager, addr string) error {
 		proxy.ServeHTTP(w, r)
 	})
 
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", cm.handleReadyz)
+
 	fileServer := http.FileServer(http.FS(distFS))
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		path := r.URL.Path
@@ -478,8 +563,81 @@ func runServer(cm *ClusterManager, addr string) error {
 		fileServer.ServeHTTP(w, r)
 	})
 
-	log.Printf("Starting Dashboard at http://%s (Contexts: %v)", addr, cm.contexts)
-	return http.ListenAndServe(addr, mux)
+	contexts, _ := cm.contextList()
+	log.Printf("Starting Dashboard at http://%s (Contexts: %v)", addr, contexts)
+	return http.ListenAndServe(addr, cm.instrument(mux))
+}
+
+// discover runs discoverAPIs against a context and records its latency.
+func (cm *ClusterManager) discover(ctxName string) (discoveryResponse, error) {
+	discoveryClient, err := cm.discoveryFor(ctxName)
+	if err != nil {
+		return discoveryResponse{}, err
+	}
+	start := time.Now()
+	resp, err := discoverAPIs(discoveryClient)
+	discoveryDuration.WithLabelValues(ctxName).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// discoverAPIs lists the API versions and top-level resources a cluster
+// serves. Groups that fail discovery are skipped as long as others answer.
+func discoverAPIs(discoveryClient discovery.DiscoveryInterface) (discoveryResponse, error) {
+	groups, resourceLists, err := discoveryClient.ServerGroupsAndResources()
+	if err != nil && len(resourceLists) == 0 {
+		return discoveryResponse{}, err
+	}
+
+	versionSet := make(map[string]bool)
+	for _, group := range groups {
+		if group == nil {
+			continue
+		}
+		for _, version := range group.Versions {
+			if version.GroupVersion != "" {
+				versionSet[version.GroupVersion] = true
+			}
+		}
+	}
+
+	var resources []discoveryResource
+	for _, resourceList := range resourceLists {
+		if resourceList == nil {
+			continue
+		}
+		if resourceList.GroupVersion != "" {
+			versionSet[resourceList.GroupVersion] = true
+		}
+		for _, resource := range resourceList.APIResources {
+			if strings.Contains(resource.Name, "/") {
+				continue
+			}
+			resources = append(resources, discoveryResource{
+				Name:         resource.Name,
+				ShortNames:   resource.ShortNames,
+				APIVersion:   resourceList.GroupVersion,
+				Namespaced:   resource.Namespaced,
+				Kind:         resource.Kind,
+				Verbs:        resource.Verbs,
+				Categories:   resource.Categories,
+				SingularName: resource.SingularName,
+			})
+		}
+	}
+
+	versions := make([]string, 0, len(versionSet))
+	for version := range versionSet {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].APIVersion == resources[j].APIVersion {
+			return resources[i].Name < resources[j].Name
+		}
+		return resources[i].APIVersion < resources[j].APIVersion
+	})
+
+	return discoveryResponse{APIVersions: versions, APIResources: resources}, nil
 }
 
 func handleWebsocket(client kubecli.KubevirtClient, w http.ResponseWriter, r *http.Request) {
@@ -491,90 +649,50 @@ func handleWebsocket(client kubecli.KubevirtClient, w http.ResponseWriter, r *ht
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("websocket upgrade failed: %v", err)
 		return
 	}
+	conn := &consoleConn{Conn: ws}
 	defer conn.Close()
 
-	stdinReader, stdinWriter := io.Pipe()
-	stdoutReader, stdoutWriter := io.Pipe()
-
-	resChan := make(chan error, 1)
-	runningChan := make(chan error, 1)
-
-	go func() {
-		var err error
-		if wsType == "vnc" {
-			log.Printf("Attempting VNC connection for %s/%s", namespace, vmi)
-			vnc, vncErr := client.VirtualMachineInstance(namespace).VNC(vmi)
-			err = vncErr
-			runningChan <- err
-			if err == nil {
-				log.Printf("VNC stream established for %s/%s", namespace, vmi)
-				resChan <- vnc.Stream(kvcorev1.StreamOptions{In: stdinReader, Out: stdoutWriter})
-			} else {
-				log.Printf("VNC connection failed for %s/%s: %v", namespace, vmi, err)
-			}
+	var stream kvcorev1.StreamInterface
+	if wsType == "vnc" {
+		log.Printf("Attempting VNC connection for %s/%s", namespace, vmi)
+		stream, err = client.VirtualMachineInstance(namespace).VNC(vmi)
+		if err != nil {
+			log.Printf("VNC connection failed for %s/%s: %v", namespace, vmi, err)
 		} else {
-			console, consoleErr := client.VirtualMachineInstance(namespace).SerialConsole(vmi, &kvcorev1.SerialConsoleOptions{ConnectionTimeout: 10 * time.Minute})
-			err = consoleErr
-			runningChan <- err
-			if err == nil {
-				resChan <- console.Stream(kvcorev1.StreamOptions{In: stdinReader, Out: stdoutWriter})
-			}
+			log.Printf("VNC stream established for %s/%s", namespace, vmi)
 		}
-	}()
-
-	if err := <-runningChan; err != nil {
-		_ = conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("console error: %v", err)))
+	} else {
+		stream, err = client.VirtualMachineInstance(namespace).SerialConsole(vmi, &kvcorev1.SerialConsoleOptions{ConnectionTimeout: consoleLimits.ConnectTimeout})
+	}
+	if err != nil {
+		_ = conn.writeMessage(websocket.TextMessage, []byte(fmt.Sprintf("console error: %v", err)))
 		return
 	}
 
-	// For VNC, do NOT send any ready message. The client expects raw RFB data immediately.
-	if wsType != "vnc" {
-		_ = conn.WriteMessage(websocket.TextMessage, []byte("serial console ready"))
-		stdinWriter.Write([]byte("\r"))
+	opts := bridgeOptions{
+		name:        fmt.Sprintf("serial console for %s/%s", namespace, vmi),
+		kind:        "serial",
+		errorPrefix: "console error",
+		doneReason:  "console stream ended",
 	}
-
-	writeErr := make(chan error, 1)
-	readErr := make(chan error, 1)
-
-	go func() {
-		buffer := make([]byte, 65536)
-		for {
-			n, err := stdoutReader.Read(buffer)
-			if n > 0 {
-				if sendErr := conn.WriteMessage(websocket.BinaryMessage, buffer[:n]); sendErr != nil {
-					writeErr <- sendErr
-					return
-				}
-			}
-			if err != nil {
-				return
-			}
-		}
-	}()
-
-	go func() {
-		for {
-			messageType, payload, err := conn.ReadMessage()
-			if err != nil {
-				readErr <- err
-				return
-			}
-			if messageType == websocket.TextMessage || messageType == websocket.BinaryMessage {
-				stdinWriter.Write(payload)
-			}
-		}
-	}()
-
-	select {
-	case <-resChan:
-	case <-writeErr:
-	case <-readErr:
+	if wsType == "vnc" {
+		opts.name = fmt.Sprintf("VNC for %s/%s", namespace, vmi)
+		opts.kind = "vnc"
+		opts.vnc = true
+	} else {
+		// For VNC, do NOT send any ready message. The client expects raw RFB data immediately.
+		_ = conn.writeMessage(websocket.TextMessage, []byte("serial console ready"))
+		opts.initialInput = []byte("\r")
 	}
+
+	bridgeConsole(r.Context(), conn, opts, func(_ context.Context, stdin io.Reader, stdout io.Writer) error {
+		return stream.Stream(kvcorev1.StreamOptions{In: stdin, Out: stdout})
+	})
 }
 
 func handlePodExec(restConfig *rest.Config, w http.ResponseWriter, r *http.Request) {
@@ -594,16 +712,17 @@ func handlePodExec(restConfig *rest.Config, w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("pod exec websocket upgrade failed: %v", err)
 		return
 	}
+	conn := &consoleConn{Conn: ws}
 	defer conn.Close()
 
 	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
-		_ = conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("exec error: %v", err)))
+		_ = conn.writeMessage(websocket.TextMessage, []byte(fmt.Sprintf("exec error: %v", err)))
 		return
 	}
 
@@ -623,100 +742,68 @@ func handlePodExec(restConfig *rest.Config, w http.ResponseWriter, r *http.Reque
 
 	executor, err := remotecommand.NewSPDYExecutor(restConfig, http.MethodPost, req.URL())
 	if err != nil {
-		_ = conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("exec error: %v", err)))
+		_ = conn.writeMessage(websocket.TextMessage, []byte(fmt.Sprintf("exec error: %v", err)))
 		return
 	}
 
-	stdinReader, stdinWriter := io.Pipe()
-	stdoutReader, stdoutWriter := io.Pipe()
-	ctx, cancel := context.WithCancel(r.Context())
-	defer cancel()
-	defer stdinWriter.Close()
-	defer stdoutWriter.Close()
-
-	execErr := make(chan error, 1)
-	go func() {
-		execErr <- executor.StreamWithContext(ctx, remotecommand.StreamOptions{
-			Stdin:  stdinReader,
-			Stdout: stdoutWriter,
+	_ = conn.writeMessage(websocket.TextMessage, []byte("pod exec ready"))
+	opts := bridgeOptions{
+		name:        fmt.Sprintf("pod exec for %s/%s", ns, pod),
+		kind:        "exec",
+		errorPrefix: "exec error",
+		doneReason:  "process exited",
+	}
+	bridgeConsole(r.Context(), conn, opts, func(ctx context.Context, stdin io.Reader, stdout io.Writer) error {
+		return executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+			Stdin:  stdin,
+			Stdout: stdout,
 			Stderr: nil,
 			Tty:    true,
 		})
-	}()
-
-	writeErr := make(chan error, 1)
-	readErr := make(chan error, 1)
-
-	go func() {
-		buffer := make([]byte, 65536)
-		for {
-			n, err := stdoutReader.Read(buffer)
-			if n > 0 {
-				if sendErr := conn.WriteMessage(websocket.BinaryMessage, buffer[:n]); sendErr != nil {
-					writeErr <- sendErr
-					return
-				}
-			}
-			if err != nil {
-				return
-			}
-		}
-	}()
-
-	go func() {
-		for {
-			messageType, payload, err := conn.ReadMessage()
-			if err != nil {
-				readErr <- err
-				return
-			}
-			if messageType == websocket.TextMessage || messageType == websocket.BinaryMessage {
-				if _, err := stdinWriter.Write(payload); err != nil {
-					readErr <- err
-					return
-				}
-			}
-		}
-	}()
-
-	_ = conn.WriteMessage(websocket.TextMessage, []byte("pod exec ready"))
-	select {
-	case err := <-execErr:
-		if err != nil {
-			_ = conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("exec error: %v", err)))
-		}
-	case <-writeErr:
-	case <-readErr:
-	}
+	})
 }
 
-func handleListVMs(client kubecli.KubevirtClient, w http.ResponseWriter, r *http.Request) {
-	q := r.URL.Query()
-	targetNs := q.Get("namespace")
-	if targetNs == "" || targetNs == "all" {
-		targetNs = metav1.NamespaceAll
+// handleListVMs lists VMs in the namespace parameter, defaultNs when it is
+// not set, or all namespaces for "all", see parseVMListOptions for filtering,
+// sorting and paging. Each VM is returned as a vmSummary joined with its VMI,
+// and with its launcher pod for pods=true. VMs come from the informer cache
+// when it is available and synced, otherwise from the API server.
+func handleListVMs(client kubecli.KubevirtClient, vmCache *vmCache, defaultNs string, w http.ResponseWriter, r *http.Request) {
+	opts, err := parseVMListOptions(r.URL.Query(), defaultNs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	nameSearch := strings.ToLower(q.Get("name"))
-	statusFilter := strings.ToLower(q.Get("status"))
-	vms, err := client.VirtualMachine(targetNs).List(context.Background(), metav1.ListOptions{})
+	items, vmis, freshness, err := listVMsAndVMIs(r.Context(), client, vmCache, opts)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	filtered := make([]kvv1.VirtualMachine, 0)
-	for _, vm := range vms.Items {
-		if nameSearch != "" && !strings.Contains(strings.ToLower(vm.Name), nameSearch) {
-			continue
-		}
-		printableStatus := strings.ToLower(string(vm.Status.PrintableStatus))
-		if statusFilter != "" && statusFilter != "all" {
-			if !strings.Contains(printableStatus, statusFilter) {
-				continue
-			}
+	pods := podLookup(noPods)
+	if r.URL.Query().Get("pods") == "true" {
+		if pods, err = listLauncherPods(r.Context(), client, opts.namespace); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		filtered = append(filtered, vm)
 	}
-	sort.Slice(filtered, func(i, j int) bool { return filtered[i].CreationTimestamp.After(filtered[j].CreationTimestamp.Time) })
+	page, total, next := selectVMs(items, vmis, opts)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"items": filtered})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items":     summarizeVMs(page, vmis, pods),
+		"total":     total,
+		"continue":  next,
+		"freshness": freshness,
+	})
+}
+
+// resolveNamespace maps a namespace parameter to the namespace to list:
+// defaultNs when it is empty and all namespaces for "all".
+func resolveNamespace(param, defaultNs string) string {
+	if param == "" {
+		param = defaultNs
+	}
+	if param == "all" {
+		return metav1.NamespaceAll
+	}
+	return param
 }
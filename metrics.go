@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubevirt_dashboard_http_requests_total",
+		Help: "HTTP requests served, by route pattern, context and status code; code is \"aborted\" for responses cut off by the handler.",
+	}, []string{"handler", "context", "code"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kubevirt_dashboard_http_request_duration_seconds",
+		Help:    "Time to serve HTTP requests, by route pattern and context. Streams are counted in kubevirt_dashboard_http_stream_duration_seconds instead.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"handler", "context"})
+	httpStreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kubevirt_dashboard_http_stream_duration_seconds",
+		Help:    "How long server-sent event streams, watches and websockets stayed open, by route pattern and context.",
+		Buckets: []float64{1, 10, 60, 300, 900, 1800, 3600, 4 * 3600, 12 * 3600},
+	}, []string{"handler", "context"})
+	proxyUpstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubevirt_dashboard_proxy_upstream_errors_total",
+		Help: "Requests the Kubernetes API proxy could not forward, by context.",
+	}, []string{"context"})
+	websocketSessions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubevirt_dashboard_websocket_sessions",
+		Help: "Open console websocket sessions, by type (serial, vnc, exec).",
+	}, []string{"type"})
+	consoleBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubevirt_dashboard_console_bytes_total",
+		Help: "Bytes bridged between console websockets and their upstream, by type and direction (in: from the browser, out: to the browser).",
+	}, []string{"type", "direction"})
+	clientInitFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubevirt_dashboard_client_init_failures_total",
+		Help: "Failed attempts to build the clients of a context.",
+	}, []string{"context"})
+	discoveryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kubevirt_dashboard_discovery_duration_seconds",
+		Help:    "Time to discover the APIs served by a context.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"context"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpRequestDuration, httpStreamDuration, proxyUpstreamErrors, websocketSessions, consoleBytes, clientInitFailures, discoveryDuration)
+}
+
+// unknownContextLabel replaces context names that are not configured, so
+// arbitrary X-Kube-Context headers cannot blow up label cardinality.
+const unknownContextLabel = "unknown"
+
+func (cm *ClusterManager) metricsContext(ctxName string) string {
+	if cm.contextExists(ctxName) {
+		return ctxName
+	}
+	return unknownContextLabel
+}
+
+// instrument counts and times every request by the mux pattern it matched.
+// Long-lived responses are timed separately so they do not skew the latency
+// of regular requests. Handlers that panic, such as with http.ErrAbortHandler
+// to cut off a response, are still recorded, with code "aborted".
+func (cm *ClusterManager) instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		completed := false
+		defer func() {
+			// The mux stores the matched pattern in the request.
+			handler := r.Pattern
+			if handler == "" {
+				handler = "none"
+			}
+			code := strconv.Itoa(recorder.status)
+			if !completed {
+				code = "aborted"
+			}
+			ctxName := cm.metricsContext(cm.contextNameForRequest(r))
+			httpRequests.WithLabelValues(handler, ctxName, code).Inc()
+			duration := httpRequestDuration
+			if recorder.streaming || r.URL.Query().Get("watch") == "true" {
+				duration = httpStreamDuration
+			}
+			duration.WithLabelValues(handler, ctxName).Observe(time.Since(start).Seconds())
+		}()
+		mux.ServeHTTP(recorder, r)
+		completed = true
+	})
+}
+
+// statusRecorder captures the response status while keeping streaming and
+// websocket upgrades working. streaming is set for server-sent events and
+// upgraded connections.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	streaming   bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+		s.wroteHeader = true
+		s.streaming = strings.HasPrefix(s.Header().Get("Content-Type"), "text/event-stream")
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	// Upgraded websockets are reported as 101 Switching Protocols.
+	s.status = http.StatusSwitchingProtocols
+	s.wroteHeader = true
+	s.streaming = true
+	return hijacker.Hijack()
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,636 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kvv1 "kubevirt.io/api/core/v1"
+	exportv1 "kubevirt.io/api/export/v1beta1"
+	"kubevirt.io/client-go/kubecli"
+	cdiv1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+)
+
+const (
+	migrationPollInterval  = 5 * time.Second
+	migrationStopTimeout   = 10 * time.Minute
+	migrationExportTimeout = 10 * time.Minute
+	migrationImportTimeout = 24 * time.Hour
+	// migrationCleanupTimeout bounds deleting the helper objects, which also
+	// runs after the migration was cancelled.
+	migrationCleanupTimeout = 30 * time.Second
+	// migrationRetention is how long finished jobs stay listed.
+	migrationRetention = 24 * time.Hour
+
+	exportTokenHeader = "x-kubevirt-export-token"
+	// bindImmediateAnnotation makes CDI import into WaitForFirstConsumer
+	// storage before the VM that consumes it exists.
+	bindImmediateAnnotation = "cdi.kubevirt.io/storage.bind.immediate.requested"
+)
+
+// Phases of a cluster migration.
+const (
+	migrationRunning   = "Running"
+	migrationSucceeded = "Succeeded"
+	migrationFailed    = "Failed"
+	migrationCancelled = "Cancelled"
+)
+
+// clusterMigrationRequest moves the VM Namespace/Name from SourceContext to
+// TargetContext. StorageClasses and Networks map source storage class and
+// Multus network names to the ones to use on the target.
+type clusterMigrationRequest struct {
+	SourceContext       string            `json:"sourceContext"`
+	Namespace           string            `json:"namespace"`
+	Name                string            `json:"name"`
+	TargetContext       string            `json:"targetContext"`
+	TargetNamespace     string            `json:"targetNamespace,omitempty"`
+	TargetName          string            `json:"targetName,omitempty"`
+	StorageClasses      map[string]string `json:"storageClasses,omitempty"`
+	DefaultStorageClass string            `json:"defaultStorageClass,omitempty"`
+	Networks            map[string]string `json:"networks,omitempty"`
+	// StopSource stops a running source VM instead of failing.
+	StopSource bool `json:"stopSource,omitempty"`
+	// Start starts the VM on the target once it is created.
+	Start bool `json:"start,omitempty"`
+}
+
+type migrationVolume struct {
+	Name         string `json:"name"`
+	Size         string `json:"size,omitempty"`
+	StorageClass string `json:"storageClass,omitempty"`
+	Phase        string `json:"phase,omitempty"`
+	Progress     string `json:"progress,omitempty"`
+}
+
+type clusterMigrationStatus struct {
+	ID        string                  `json:"id"`
+	Request   clusterMigrationRequest `json:"request"`
+	Phase     string                  `json:"phase"`
+	Step      string                  `json:"step"`
+	Message   string                  `json:"message,omitempty"`
+	Volumes   []migrationVolume       `json:"volumes"`
+	CreatedAt time.Time               `json:"createdAt"`
+	UpdatedAt time.Time               `json:"updatedAt"`
+}
+
+// clusterMigration is a running or finished migration job.
+type clusterMigration struct {
+	mu     sync.Mutex
+	status clusterMigrationStatus
+	cancel context.CancelFunc
+}
+
+func (m *clusterMigration) snapshot() clusterMigrationStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := m.status
+	status.Volumes = make([]migrationVolume, len(m.status.Volumes))
+	copy(status.Volumes, m.status.Volumes)
+	return status
+}
+
+func (m *clusterMigration) update(fn func(status *clusterMigrationStatus)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn(&m.status)
+	m.status.UpdatedAt = time.Now()
+}
+
+func (m *clusterMigration) setStep(step, message string) {
+	log.Printf("Cluster migration %s: %s %s", m.status.ID, step, message)
+	m.update(func(status *clusterMigrationStatus) {
+		status.Step = step
+		status.Message = message
+	})
+}
+
+// handleClusterMigrations serves /api/v1/cluster-migrations: GET lists the
+// migration jobs, POST starts one.
+func (cm *ClusterManager) handleClusterMigrations(w http.ResponseWriter, r *http.Request) {
+	cm.expireMigrations()
+	switch r.Method {
+	case http.MethodGet:
+		cm.mu.RLock()
+		items := make([]clusterMigrationStatus, 0, len(cm.migrations))
+		for _, m := range cm.migrations {
+			items = append(items, m.snapshot())
+		}
+		cm.mu.RUnlock()
+		sort.Slice(items, func(i, j int) bool { return items[i].CreatedAt.After(items[j].CreatedAt) })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+	case http.MethodPost:
+		var req clusterMigrationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.SourceContext == "" || req.TargetContext == "" || req.Namespace == "" || req.Name == "" {
+			http.Error(w, "sourceContext, targetContext, namespace and name are required", http.StatusBadRequest)
+			return
+		}
+		for _, ctxName := range []string{req.SourceContext, req.TargetContext} {
+			if !cm.contextExists(ctxName) {
+				http.Error(w, fmt.Sprintf("unknown context %q", ctxName), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.TargetNamespace == "" {
+			req.TargetNamespace = req.Namespace
+		}
+		if req.TargetName == "" {
+			req.TargetName = req.Name
+		}
+		if req.SourceContext == req.TargetContext && req.TargetNamespace == req.Namespace {
+			http.Error(w, "source and target must differ in context or namespace", http.StatusBadRequest)
+			return
+		}
+
+		id, err := randomID()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		now := time.Now()
+		m := &clusterMigration{
+			status: clusterMigrationStatus{
+				ID:        id,
+				Request:   req,
+				Phase:     migrationRunning,
+				Step:      "Pending",
+				Volumes:   []migrationVolume{},
+				CreatedAt: now,
+				UpdatedAt: now,
+			},
+			cancel: cancel,
+		}
+		cm.mu.Lock()
+		cm.migrations[id] = m
+		cm.mu.Unlock()
+		go cm.runClusterMigration(ctx, m)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(m.snapshot())
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleClusterMigration serves /api/v1/cluster-migrations/{id}: GET returns
+// the job status, DELETE cancels a running job or forgets a finished one.
+func (cm *ClusterManager) handleClusterMigration(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/cluster-migrations/")
+	cm.expireMigrations()
+	cm.mu.RLock()
+	m, ok := cm.migrations[id]
+	cm.mu.RUnlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("cluster migration %q not found", id), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.snapshot())
+	case http.MethodDelete:
+		if m.snapshot().Phase == migrationRunning {
+			m.cancel()
+		} else {
+			cm.mu.Lock()
+			delete(cm.migrations, id)
+			cm.mu.Unlock()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// expireMigrations forgets jobs that finished more than migrationRetention ago.
+func (cm *ClusterManager) expireMigrations() {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for id, m := range cm.migrations {
+		status := m.snapshot()
+		if status.Phase != migrationRunning && time.Since(status.UpdatedAt) > migrationRetention {
+			delete(cm.migrations, id)
+		}
+	}
+}
+
+func (cm *ClusterManager) runClusterMigration(ctx context.Context, m *clusterMigration) {
+	defer m.cancel()
+	err := cm.migrateVM(ctx, m)
+	m.update(func(status *clusterMigrationStatus) {
+		switch {
+		case err == nil:
+			status.Phase = migrationSucceeded
+		case ctx.Err() != nil:
+			status.Phase = migrationCancelled
+			status.Message = "cancelled"
+		default:
+			status.Phase = migrationFailed
+			status.Message = err.Error()
+		}
+	})
+	log.Printf("Cluster migration %s finished: %s", m.status.ID, m.snapshot().Phase)
+}
+
+// migrateVM exports the source VM's disks with a VirtualMachineExport,
+// imports them into DataVolumes on the target and recreates the VM there.
+// When it fails or is cancelled, the DataVolumes created on the target are
+// deleted and a source VM it stopped gets its run strategy back.
+func (cm *ClusterManager) migrateVM(ctx context.Context, m *clusterMigration) (err error) {
+	req := m.snapshot().Request
+	source, _, _, err := cm.clientsFor(req.SourceContext)
+	if err != nil {
+		return fmt.Errorf("source context: %v", err)
+	}
+	target, _, _, err := cm.clientsFor(req.TargetContext)
+	if err != nil {
+		return fmt.Errorf("target context: %v", err)
+	}
+
+	m.setStep("Preparing", "checking source and target")
+	vm, err := source.VirtualMachine(req.Namespace).Get(ctx, req.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if _, err := target.VirtualMachine(req.TargetNamespace).Get(ctx, req.TargetName, metav1.GetOptions{}); err == nil {
+		return fmt.Errorf("VM %s/%s already exists on %s", req.TargetNamespace, req.TargetName, req.TargetContext)
+	} else if !apierrors.IsNotFound(err) {
+		return err
+	}
+	stopped, err := stopSourceVM(ctx, m, source, req)
+	if stopped {
+		defer func() {
+			if err != nil {
+				restoreSourceVM(source, vm)
+			}
+		}()
+	}
+	if err != nil {
+		return err
+	}
+
+	m.setStep("Exporting", "waiting for the VirtualMachineExport to become ready")
+	token, err := randomID()
+	if err != nil {
+		return err
+	}
+	export, cleanupExport, err := exportVM(ctx, source, req.Namespace, req.Name, token)
+	defer cleanupExport()
+	if err != nil {
+		return err
+	}
+
+	m.setStep("Importing", "creating DataVolumes on the target")
+	importer, cleanupImporter, err := createImportCredentials(ctx, target, req.TargetNamespace, export.Status.Links.External.Cert, token)
+	defer cleanupImporter()
+	if err != nil {
+		return err
+	}
+	volumes, cleanupVolumes, err := createImportDataVolumes(ctx, source, target, req, export.Status.Links.External.Volumes, importer)
+	defer func() {
+		if err != nil {
+			cleanupVolumes()
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	m.update(func(status *clusterMigrationStatus) { status.Volumes = volumes })
+	if err := waitForImports(ctx, m, target, req.TargetNamespace); err != nil {
+		return err
+	}
+
+	m.setStep("CreatingVM", fmt.Sprintf("creating VM %s/%s", req.TargetNamespace, req.TargetName))
+	if _, err := target.VirtualMachine(req.TargetNamespace).Create(ctx, targetVM(vm, req), metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	m.setStep("Done", fmt.Sprintf("VM %s/%s created on %s", req.TargetNamespace, req.TargetName, req.TargetContext))
+	return nil
+}
+
+// stopSourceVM makes sure the source VM is not running, since the disks of a
+// running VM cannot be exported. It reports whether it asked the VM to stop.
+func stopSourceVM(ctx context.Context, m *clusterMigration, source kubecli.KubevirtClient, req clusterMigrationRequest) (bool, error) {
+	_, err := source.VirtualMachineInstance(req.Namespace).Get(ctx, req.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !req.StopSource {
+		return false, fmt.Errorf("VM %s/%s is running; stop it first or set stopSource", req.Namespace, req.Name)
+	}
+	m.setStep("StoppingSource", "waiting for the source VM to stop")
+	if err := source.VirtualMachine(req.Namespace).Stop(ctx, req.Name, &kvv1.StopOptions{}); err != nil {
+		return false, err
+	}
+	return true, wait.PollUntilContextTimeout(ctx, migrationPollInterval, migrationStopTimeout, true, func(ctx context.Context) (bool, error) {
+		_, err := source.VirtualMachineInstance(req.Namespace).Get(ctx, req.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+}
+
+// restoreSourceVM puts back the running and runStrategy fields of a source VM
+// that stopSourceVM stopped. A Manual VM is started again explicitly.
+func restoreSourceVM(source kubecli.KubevirtClient, vm *kvv1.VirtualMachine) {
+	ctx, cancel := context.WithTimeout(context.Background(), migrationCleanupTimeout)
+	defer cancel()
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"running": vm.Spec.Running, "runStrategy": vm.Spec.RunStrategy},
+	})
+	if err == nil {
+		_, err = source.VirtualMachine(vm.Namespace).Patch(ctx, vm.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	}
+	if runStrategy, _ := vm.RunStrategy(); err == nil && runStrategy == kvv1.RunStrategyManual {
+		err = source.VirtualMachine(vm.Namespace).Start(ctx, vm.Name, &kvv1.StartOptions{})
+	}
+	if err != nil {
+		log.Printf("Failed to restart source VM %s/%s: %v", vm.Namespace, vm.Name, err)
+	}
+}
+
+// exportVM creates a VirtualMachineExport protected by token and waits for
+// its external link. The returned cleanup deletes the export and its token.
+func exportVM(ctx context.Context, client kubecli.KubevirtClient, ns, name, token string) (*exportv1.VirtualMachineExport, func(), error) {
+	var secretName, exportName string
+	cleanup := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), migrationCleanupTimeout)
+		defer cancel()
+		if exportName != "" {
+			_ = client.VirtualMachineExport(ns).Delete(ctx, exportName, metav1.DeleteOptions{})
+		}
+		if secretName != "" {
+			_ = client.CoreV1().Secrets(ns).Delete(ctx, secretName, metav1.DeleteOptions{})
+		}
+	}
+
+	secret, err := client.CoreV1().Secrets(ns).Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "kubevirt-dashboard-export-"},
+		StringData: map[string]string{"token": token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, cleanup, err
+	}
+	secretName = secret.Name
+
+	apiGroup := kvv1.SchemeGroupVersion.Group
+	export, err := client.VirtualMachineExport(ns).Create(ctx, &exportv1.VirtualMachineExport{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "kubevirt-dashboard-"},
+		Spec: exportv1.VirtualMachineExportSpec{
+			Source:         corev1.TypedLocalObjectReference{APIGroup: &apiGroup, Kind: "VirtualMachine", Name: name},
+			TokenSecretRef: &secretName,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, cleanup, err
+	}
+	exportName = export.Name
+
+	err = wait.PollUntilContextTimeout(ctx, migrationPollInterval, migrationExportTimeout, true, func(ctx context.Context) (bool, error) {
+		export, err = client.VirtualMachineExport(ns).Get(ctx, exportName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return export.Status != nil && export.Status.Phase == exportv1.Ready, nil
+	})
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("waiting for VirtualMachineExport %s/%s: %v", ns, exportName, err)
+	}
+	if export.Status.Links == nil || export.Status.Links.External == nil {
+		return nil, cleanup, fmt.Errorf("VirtualMachineExport %s/%s has no external link; expose virt-exportproxy with an Ingress or Route", ns, exportName)
+	}
+	return export, cleanup, nil
+}
+
+type importCredentials struct {
+	certConfigMap string
+	headerSecret  string
+}
+
+// createImportCredentials stores the export's CA certificate and token on the
+// target, where CDI importers pick them up. The returned cleanup deletes them.
+func createImportCredentials(ctx context.Context, client kubecli.KubevirtClient, ns, cert, token string) (importCredentials, func(), error) {
+	var creds importCredentials
+	cleanup := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), migrationCleanupTimeout)
+		defer cancel()
+		if creds.certConfigMap != "" {
+			_ = client.CoreV1().ConfigMaps(ns).Delete(ctx, creds.certConfigMap, metav1.DeleteOptions{})
+		}
+		if creds.headerSecret != "" {
+			_ = client.CoreV1().Secrets(ns).Delete(ctx, creds.headerSecret, metav1.DeleteOptions{})
+		}
+	}
+
+	configMap, err := client.CoreV1().ConfigMaps(ns).Create(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "kubevirt-dashboard-import-"},
+		Data:       map[string]string{"ca.pem": cert},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return creds, cleanup, err
+	}
+	creds.certConfigMap = configMap.Name
+
+	secret, err := client.CoreV1().Secrets(ns).Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "kubevirt-dashboard-import-"},
+		StringData: map[string]string{"token": exportTokenHeader + ":" + token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return creds, cleanup, err
+	}
+	creds.headerSecret = secret.Name
+	return creds, cleanup, nil
+}
+
+// createImportDataVolumes creates one DataVolume per exported volume, named
+// like the source PVC so the VM's volume references stay valid. The returned
+// cleanup deletes the DataVolumes it created.
+func createImportDataVolumes(ctx context.Context, source, target kubecli.KubevirtClient, req clusterMigrationRequest, exported []exportv1.VirtualMachineExportVolume, creds importCredentials) ([]migrationVolume, func(), error) {
+	volumes := make([]migrationVolume, 0, len(exported))
+	var created []string
+	cleanup := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), migrationCleanupTimeout)
+		defer cancel()
+		for _, name := range created {
+			_ = target.CdiClient().CdiV1beta1().DataVolumes(req.TargetNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+		}
+	}
+	for _, volume := range exported {
+		url := exportVolumeURL(volume)
+		if url == "" {
+			return nil, cleanup, fmt.Errorf("volume %s has no raw or gzip export", volume.Name)
+		}
+		pvc, err := source.CoreV1().PersistentVolumeClaims(req.Namespace).Get(ctx, volume.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, cleanup, err
+		}
+		size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok && capacity.Cmp(size) > 0 {
+			size = capacity
+		}
+
+		storage := &cdiv1.StorageSpec{
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+			},
+		}
+		if class := targetStorageClass(req, pvc.Spec.StorageClassName); class != "" {
+			storage.StorageClassName = &class
+		}
+		dv := &cdiv1.DataVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        volume.Name,
+				Namespace:   req.TargetNamespace,
+				Annotations: map[string]string{bindImmediateAnnotation: "true"},
+			},
+			Spec: cdiv1.DataVolumeSpec{
+				Source: &cdiv1.DataVolumeSource{
+					HTTP: &cdiv1.DataVolumeSourceHTTP{
+						URL:                url,
+						CertConfigMap:      creds.certConfigMap,
+						SecretExtraHeaders: []string{creds.headerSecret},
+					},
+				},
+				Storage: storage,
+			},
+		}
+		if _, err := target.CdiClient().CdiV1beta1().DataVolumes(req.TargetNamespace).Create(ctx, dv, metav1.CreateOptions{}); err != nil {
+			return nil, cleanup, fmt.Errorf("creating DataVolume %s: %v", volume.Name, err)
+		}
+		created = append(created, volume.Name)
+		v := migrationVolume{Name: volume.Name, Size: size.String(), Phase: string(cdiv1.Pending)}
+		if storage.StorageClassName != nil {
+			v.StorageClass = *storage.StorageClassName
+		}
+		volumes = append(volumes, v)
+	}
+	return volumes, cleanup, nil
+}
+
+// exportVolumeURL prefers the compressed image of an exported volume.
+func exportVolumeURL(volume exportv1.VirtualMachineExportVolume) string {
+	var raw string
+	for _, format := range volume.Formats {
+		switch format.Format {
+		case exportv1.KubeVirtGz:
+			return format.Url
+		case exportv1.KubeVirtRaw:
+			raw = format.Url
+		}
+	}
+	return raw
+}
+
+// targetStorageClass maps a source storage class to the target one. An empty
+// result leaves the choice to the target's default storage class.
+func targetStorageClass(req clusterMigrationRequest, sourceClass *string) string {
+	if sourceClass != nil {
+		if class, ok := req.StorageClasses[*sourceClass]; ok {
+			return class
+		}
+	}
+	return req.DefaultStorageClass
+}
+
+// waitForImports polls the DataVolumes until all imports succeeded, recording
+// their progress in the job.
+func waitForImports(ctx context.Context, m *clusterMigration, client kubecli.KubevirtClient, ns string) error {
+	return wait.PollUntilContextTimeout(ctx, migrationPollInterval, migrationImportTimeout, true, func(ctx context.Context) (bool, error) {
+		volumes := m.snapshot().Volumes
+		done := true
+		for i, volume := range volumes {
+			dv, err := client.CdiClient().CdiV1beta1().DataVolumes(ns).Get(ctx, volume.Name, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			volumes[i].Phase = string(dv.Status.Phase)
+			volumes[i].Progress = string(dv.Status.Progress)
+			switch dv.Status.Phase {
+			case cdiv1.Succeeded:
+			case cdiv1.Failed:
+				return false, fmt.Errorf("import of DataVolume %s failed", volume.Name)
+			default:
+				done = false
+			}
+		}
+		m.update(func(status *clusterMigrationStatus) {
+			status.Volumes = volumes
+			status.Message = "waiting for DataVolume imports"
+		})
+		return done, nil
+	})
+}
+
+// targetVM rewrites the source VM for the target: server-set metadata and
+// status are dropped, DataVolume templates are replaced by the imported
+// DataVolumes, and Multus networks are mapped.
+func targetVM(vm *kvv1.VirtualMachine, req clusterMigrationRequest) *kvv1.VirtualMachine {
+	annotations := make(map[string]string)
+	for key, value := range vm.Annotations {
+		if key == corev1.LastAppliedConfigAnnotation || strings.HasPrefix(key, "kubevirt.io/") {
+			continue
+		}
+		annotations[key] = value
+	}
+	out := &kvv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        req.TargetName,
+			Namespace:   req.TargetNamespace,
+			Labels:      vm.Labels,
+			Annotations: annotations,
+		},
+		Spec: *vm.Spec.DeepCopy(),
+	}
+	out.Spec.DataVolumeTemplates = nil
+	out.Spec.Running = nil
+	runStrategy := kvv1.RunStrategyHalted
+	if req.Start {
+		runStrategy = kvv1.RunStrategyAlways
+	}
+	out.Spec.RunStrategy = &runStrategy
+
+	if out.Spec.Template != nil {
+		for i, network := range out.Spec.Template.Spec.Networks {
+			if network.Multus == nil {
+				continue
+			}
+			if mapped, ok := req.Networks[network.Multus.NetworkName]; ok {
+				out.Spec.Template.Spec.Networks[i].Multus.NetworkName = mapped
+			}
+		}
+	}
+	return out
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// readyzTimeout bounds the reachability check of the default context. It
+// stays below the probe timeout in manifest/deploy.yaml.
+const readyzTimeout = 4 * time.Second
+
+type probeCheck struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// handleHealthz reports that the process is alive and serving requests.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeProbe(w, r, "healthz", []probeCheck{{name: "ping", run: func(context.Context) error { return nil }}})
+}
+
+// handleReadyz reports whether the embedded UI is present and the default
+// context answers discovery requests.
+func (cm *ClusterManager) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	writeProbe(w, r, "readyz", []probeCheck{
+		{name: "ui", run: checkEmbeddedUI},
+		{name: "default-context", run: cm.checkDefaultContext},
+	})
+}
+
+func checkEmbeddedUI(context.Context) error {
+	if _, err := fs.Stat(uiContent, "ui/dist/index.html"); err != nil {
+		return fmt.Errorf("embedded UI missing: %v", err)
+	}
+	return nil
+}
+
+func (cm *ClusterManager) checkDefaultContext(ctx context.Context) error {
+	_, defaultCtx := cm.contextList()
+	if defaultCtx == "" {
+		return errors.New("no default context configured")
+	}
+	discoveryClient, err := cm.discoveryFor(defaultCtx)
+	if err != nil {
+		return fmt.Errorf("context %s: %v", defaultCtx, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, readyzTimeout)
+	defer cancel()
+	if err := discoveryClient.RESTClient().Get().AbsPath("/version").Do(ctx).Error(); err != nil {
+		return fmt.Errorf("context %s unreachable: %v", defaultCtx, err)
+	}
+	return nil
+}
+
+// writeProbe runs the checks and answers like the Kubernetes API server:
+// "ok" or 503, with one [+]/[-] line per check when verbose is set.
+func writeProbe(w http.ResponseWriter, r *http.Request, probe string, checks []probeCheck) {
+	_, verbose := r.URL.Query()["verbose"]
+	var out strings.Builder
+	failed := false
+	for _, check := range checks {
+		if err := check.run(r.Context()); err != nil {
+			failed = true
+			fmt.Fprintf(&out, "[-]%s failed: %v\n", check.name, err)
+		} else {
+			fmt.Fprintf(&out, "[+]%s ok\n", check.name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if failed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		// Failures always list the checks so probe events say what broke.
+		fmt.Fprintf(w, "%s%s check failed\n", out.String(), probe)
+		return
+	}
+	if verbose {
+		fmt.Fprintf(w, "%s%s check passed\n", out.String(), probe)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	clusterSecretLabel      = "kubevirt-dashboard/cluster"
+	clusterNameAnnotation   = "kubevirt-dashboard/cluster-name"
+	clusterSecretNamePrefix = "kubevirt-dashboard-cluster-"
+	clusterTestTimeout      = 10 * time.Second
+)
+
+var clusterSecretNamespace = defaultClusterSecretNamespace()
+
+// registeredCluster is a context added through the API and persisted as a
+// Secret in clusterSecretNamespace.
+type registeredCluster struct {
+	secret string
+	server string
+	config *rest.Config
+}
+
+// clusterRegistration is the request body to add or update a cluster. Either
+// Kubeconfig (optionally with Context) or Server and Token must be set.
+type clusterRegistration struct {
+	Name                  string `json:"name"`
+	Kubeconfig            string `json:"kubeconfig,omitempty"`
+	Context               string `json:"context,omitempty"`
+	Server                string `json:"server,omitempty"`
+	CAData                string `json:"caData,omitempty"`
+	Token                 string `json:"token,omitempty"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTLSVerify,omitempty"`
+}
+
+func (reg clusterRegistration) hasCredentials() bool {
+	return reg.Kubeconfig != "" || reg.Server != "" || reg.Token != "" || reg.CAData != ""
+}
+
+type registeredClusterInfo struct {
+	Name          string `json:"name"`
+	Server        string `json:"server"`
+	Secret        string `json:"secret"`
+	ServerVersion string `json:"serverVersion,omitempty"`
+}
+
+func defaultClusterSecretNamespace() string {
+	if ns := serviceAccountNamespace(); ns != "" {
+		return ns
+	}
+	return "kubevirt-dashboard"
+}
+
+// serviceAccountNamespace returns the namespace of the pod the dashboard
+// runs in, or "" outside a cluster.
+func serviceAccountNamespace() string {
+	data, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// secretsClient returns the Secrets API of the cluster the dashboard runs in,
+// or of the default context when running outside a cluster.
+func (cm *ClusterManager) secretsClient() (corev1client.SecretInterface, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		_, defaultCtx := cm.contextList()
+		if restConfig, err = cm.restConfigFor(defaultCtx); err != nil {
+			return nil, err
+		}
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return clientset.CoreV1().Secrets(clusterSecretNamespace), nil
+}
+
+// loadRegisteredClusters adds every cluster stored as a Secret to the manager.
+func (cm *ClusterManager) loadRegisteredClusters(ctx context.Context) error {
+	secrets, err := cm.secretsClient()
+	if err != nil {
+		return err
+	}
+	list, err := secrets.List(ctx, metav1.ListOptions{LabelSelector: clusterSecretLabel + "=true"})
+	if err != nil {
+		return err
+	}
+	var added []string
+	for _, secret := range list.Items {
+		name := secret.Annotations[clusterNameAnnotation]
+		restConfig, err := restConfigFromSecretData(secret.Data)
+		if err != nil || name == "" {
+			log.Printf("Skipping cluster secret %s/%s: %v", secret.Namespace, secret.Name, err)
+			continue
+		}
+		if cm.contextExists(name) {
+			log.Printf("Skipping cluster secret %s/%s: context %q already exists", secret.Namespace, secret.Name, name)
+			continue
+		}
+		cm.mu.Lock()
+		cm.registered[name] = &registeredCluster{secret: secret.Name, server: restConfig.Host, config: restConfig}
+		cm.mu.Unlock()
+		added = append(added, name)
+	}
+	if len(added) > 0 {
+		log.Printf("Loaded %d registered clusters from %s", len(added), clusterSecretNamespace)
+		cm.contextsChanged(contextsEvent{Added: added})
+	}
+	return nil
+}
+
+// registeredLocked returns the registered cluster of a context. Kubeconfig
+// contexts take precedence over registered clusters of the same name, which
+// can only appear when the kubeconfig changes after the registration.
+// cm.mu must be held.
+func (cm *ClusterManager) registeredLocked(name string) (*registeredCluster, bool) {
+	if _, ok := cm.fingerprints[name]; ok {
+		return nil, false
+	}
+	registered, ok := cm.registered[name]
+	return registered, ok
+}
+
+func (cm *ClusterManager) contextExists(name string) bool {
+	contexts, _ := cm.contextList()
+	for _, c := range contexts {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// contextsChanged rebuilds the context list and publishes ev with it.
+func (cm *ClusterManager) contextsChanged(ev contextsEvent) {
+	cm.mu.Lock()
+	cm.rebuildContextsLocked()
+	ev.Contexts = append([]string(nil), cm.contexts...)
+	ev.Default = cm.defaultCtx
+	cm.mu.Unlock()
+	cm.publish(ev)
+}
+
+// handleClusterRegistrations serves /api/v1/cluster-registrations: GET lists
+// registered clusters, POST adds one. With ?dryRun=true the connection is only
+// tested and nothing is stored.
+func (cm *ClusterManager) handleClusterRegistrations(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cm.mu.RLock()
+		items := make([]registeredClusterInfo, 0, len(cm.registered))
+		for name, c := range cm.registered {
+			items = append(items, registeredClusterInfo{Name: name, Server: c.server, Secret: c.secret})
+		}
+		cm.mu.RUnlock()
+		sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items, "namespace": clusterSecretNamespace})
+	case http.MethodPost:
+		var reg clusterRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateClusterName(reg.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if cm.contextExists(reg.Name) {
+			http.Error(w, fmt.Sprintf("context %q already exists", reg.Name), http.StatusConflict)
+			return
+		}
+		data, restConfig, version, err := prepareRegistration(r.Context(), reg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		info := registeredClusterInfo{Name: reg.Name, Server: restConfig.Host, ServerVersion: version}
+		if r.URL.Query().Get("dryRun") != "true" {
+			secret, err := cm.createClusterSecret(r.Context(), reg.Name, data)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			info.Secret = secret
+			cm.mu.Lock()
+			cm.registered[reg.Name] = &registeredCluster{secret: secret, server: restConfig.Host, config: restConfig}
+			cm.mu.Unlock()
+			cm.contextsChanged(contextsEvent{Added: []string{reg.Name}})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if info.Secret != "" {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(info)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleClusterRegistration serves /api/v1/cluster-registrations/{name}: PUT
+// renames the cluster and/or replaces its credentials, DELETE removes it.
+func (cm *ClusterManager) handleClusterRegistration(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/cluster-registrations/")
+	cm.mu.RLock()
+	existing, ok := cm.registered[name]
+	cm.mu.RUnlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("registered cluster %q not found", name), http.StatusNotFound)
+		return
+	}
+
+	secrets, err := cm.secretsClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var reg clusterRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		newName := reg.Name
+		if newName == "" {
+			newName = name
+		}
+		if err := validateClusterName(newName); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if newName != name && cm.contextExists(newName) {
+			http.Error(w, fmt.Sprintf("context %q already exists", newName), http.StatusConflict)
+			return
+		}
+		restConfig := existing.config
+		var data map[string][]byte
+		info := registeredClusterInfo{Name: newName, Secret: existing.secret}
+		if reg.hasCredentials() {
+			var version string
+			if data, restConfig, version, err = prepareRegistration(r.Context(), reg); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			info.ServerVersion = version
+		}
+		info.Server = restConfig.Host
+		if r.URL.Query().Get("dryRun") != "true" {
+			secret, err := secrets.Get(r.Context(), existing.secret, metav1.GetOptions{})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if secret.Annotations == nil {
+				secret.Annotations = map[string]string{}
+			}
+			secret.Annotations[clusterNameAnnotation] = newName
+			if data != nil {
+				secret.Data = data
+			}
+			if _, err := secrets.Update(r.Context(), secret, metav1.UpdateOptions{}); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			cm.mu.Lock()
+			_, active := cm.registeredLocked(name)
+			delete(cm.registered, name)
+			cm.invalidateLocked(name)
+			cm.invalidateLocked(newName)
+			if active && (newName != name || restConfig.Host != existing.server) {
+				cm.stopVMCacheLocked(name)
+			}
+			cm.registered[newName] = &registeredCluster{secret: existing.secret, server: restConfig.Host, config: restConfig}
+			cm.mu.Unlock()
+			ev := contextsEvent{Changed: []string{newName}}
+			if newName != name {
+				ev = contextsEvent{Added: []string{newName}, Removed: []string{name}}
+			}
+			cm.contextsChanged(ev)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	case http.MethodDelete:
+		// A Secret deleted behind our back still unregisters the cluster.
+		if err := secrets.Delete(r.Context(), existing.secret, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cm.mu.Lock()
+		if _, active := cm.registeredLocked(name); active {
+			cm.stopVMCacheLocked(name)
+		}
+		delete(cm.registered, name)
+		cm.invalidateLocked(name)
+		cm.mu.Unlock()
+		cm.contextsChanged(contextsEvent{Removed: []string{name}})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func validateClusterName(name string) error {
+	if name == "" {
+		return errors.New("missing cluster name")
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return fmt.Errorf("invalid cluster name %q: %s", name, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func (cm *ClusterManager) createClusterSecret(ctx context.Context, name string, data map[string][]byte) (string, error) {
+	secrets, err := cm.secretsClient()
+	if err != nil {
+		return "", err
+	}
+	secret, err := secrets.Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: clusterSecretNamePrefix,
+			Namespace:    clusterSecretNamespace,
+			Labels:       map[string]string{clusterSecretLabel: "true"},
+			Annotations:  map[string]string{clusterNameAnnotation: name},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+	return secret.Name, nil
+}
+
+// prepareRegistration turns a registration into Secret data, builds the REST
+// config from it and checks that the cluster answers.
+func prepareRegistration(ctx context.Context, reg clusterRegistration) (map[string][]byte, *rest.Config, string, error) {
+	data, err := reg.secretData()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	restConfig, err := restConfigFromSecretData(data)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	version, err := testClusterConnection(ctx, restConfig)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("cluster is not reachable: %v", err)
+	}
+	return data, restConfig, version, nil
+}
+
+func (reg clusterRegistration) secretData() (map[string][]byte, error) {
+	if reg.Kubeconfig == "" {
+		if reg.Server == "" || reg.Token == "" {
+			return nil, errors.New("either kubeconfig or server and token are required")
+		}
+		data := map[string][]byte{
+			"server": []byte(reg.Server),
+			"token":  []byte(reg.Token),
+		}
+		if reg.CAData != "" {
+			data["ca.crt"] = []byte(reg.CAData)
+		}
+		if reg.InsecureSkipTLSVerify {
+			data["insecure-skip-tls-verify"] = []byte("true")
+		}
+		return data, nil
+	}
+
+	config, err := clientcmd.Load([]byte(reg.Kubeconfig))
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig: %v", err)
+	}
+	if reg.Context != "" {
+		config.CurrentContext = reg.Context
+	}
+	if err := clientcmdapi.MinifyConfig(config); err != nil {
+		return nil, err
+	}
+	if err := checkSelfContained(config); err != nil {
+		return nil, err
+	}
+	kubeconfigData, err := clientcmd.Write(*config)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{"kubeconfig": kubeconfigData}, nil
+}
+
+// checkSelfContained rejects kubeconfigs that would make the dashboard read
+// local files or run commands, since their content comes from API clients.
+func checkSelfContained(config *clientcmdapi.Config) error {
+	for name, cluster := range config.Clusters {
+		if cluster.CertificateAuthority != "" {
+			return fmt.Errorf("cluster %q references a local CA file, embed certificate-authority-data instead", name)
+		}
+	}
+	for name, user := range config.AuthInfos {
+		switch {
+		case user.Exec != nil || user.AuthProvider != nil:
+			return fmt.Errorf("user %q uses an exec or auth provider plugin, which is not supported for registered clusters", name)
+		case user.ClientCertificate != "" || user.ClientKey != "" || user.TokenFile != "":
+			return fmt.Errorf("user %q references local credential files, embed the data instead", name)
+		}
+	}
+	return nil
+}
+
+func restConfigFromSecretData(data map[string][]byte) (*rest.Config, error) {
+	if kubeconfigData, ok := data["kubeconfig"]; ok {
+		config, err := clientcmd.Load(kubeconfigData)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkSelfContained(config); err != nil {
+			return nil, err
+		}
+		return clientcmd.NewNonInteractiveClientConfig(*config, config.CurrentContext, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
+	}
+	server := string(data["server"])
+	if server == "" {
+		return nil, errors.New("secret has neither kubeconfig nor server")
+	}
+	insecure, _ := strconv.ParseBool(string(data["insecure-skip-tls-verify"]))
+	return &rest.Config{
+		Host:        server,
+		BearerToken: string(data["token"]),
+		TLSClientConfig: rest.TLSClientConfig{
+			CAData:   data["ca.crt"],
+			Insecure: insecure,
+		},
+	}, nil
+}
+
+func testClusterConnection(ctx context.Context, restConfig *rest.Config) (string, error) {
+	testConfig := rest.CopyConfig(restConfig)
+	testConfig.Timeout = clusterTestTimeout
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(testConfig)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, clusterTestTimeout)
+	defer cancel()
+	body, err := discoveryClient.RESTClient().Get().AbsPath("/version").Do(ctx).Raw()
+	if err != nil {
+		return "", err
+	}
+	var version struct {
+		GitVersion string `json:"gitVersion"`
+	}
+	if err := json.Unmarshal(body, &version); err != nil {
+		return "", err
+	}
+	return version.GitVersion, nil
+}
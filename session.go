@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Close codes sent to the browser when the server ends a console session.
+const (
+	closeSessionIdle    = 4000
+	closeSessionExpired = 4001
+)
+
+// sessionLimits bounds how long serial, VNC and pod exec sessions may live.
+// A zero idle timeout or max duration disables that limit.
+type sessionLimits struct {
+	ConnectTimeout time.Duration
+	IdleTimeout    time.Duration
+	MaxDuration    time.Duration
+	Warning        time.Duration
+	KeepAlive      time.Duration
+}
+
+var consoleLimits = sessionLimits{
+	ConnectTimeout: 10 * time.Minute,
+	IdleTimeout:    30 * time.Minute,
+	Warning:        time.Minute,
+	KeepAlive:      30 * time.Second,
+}
+
+type consoleSettingsResponse struct {
+	IdleTimeoutSeconds int `json:"idleTimeoutSeconds"`
+	MaxDurationSeconds int `json:"maxDurationSeconds"`
+	WarningSeconds     int `json:"warningSeconds"`
+}
+
+func (l sessionLimits) settings() consoleSettingsResponse {
+	return consoleSettingsResponse{
+		IdleTimeoutSeconds: int(l.IdleTimeout / time.Second),
+		MaxDurationSeconds: int(l.MaxDuration / time.Second),
+		WarningSeconds:     int(l.Warning / time.Second),
+	}
+}
+
+// consoleConn serializes writes to a console websocket, which is shared by the
+// output pump and the session monitor.
+type consoleConn struct {
+	*websocket.Conn
+	writeMu sync.Mutex
+}
+
+func (c *consoleConn) writeMessage(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = c.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.Conn.WriteMessage(messageType, data)
+}
+
+// closeWithReason tells the client why the session ended before the
+// connection is torn down.
+func (c *consoleConn) closeWithReason(code int, reason string) {
+	// Close frame payloads are limited to 125 bytes including the code.
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	_ = c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+}
+
+// sessionMonitor enforces sessionLimits for a single console session.
+type sessionMonitor struct {
+	limits    sessionLimits
+	started   time.Time
+	lastInput atomic.Int64
+}
+
+func newSessionMonitor(limits sessionLimits) *sessionMonitor {
+	m := &sessionMonitor{limits: limits, started: time.Now()}
+	m.touch()
+	return m
+}
+
+// touch records user input and resets the idle timer.
+func (m *sessionMonitor) touch() {
+	m.lastInput.Store(time.Now().UnixNano())
+}
+
+// run blocks until ctx is done or a limit is reached. warn is called once
+// when a limit is about to be hit; the returned error carries the close code
+// and reason for the client, or is nil when ctx ended first.
+func (m *sessionMonitor) run(ctx context.Context, warn func(msg string)) *websocket.CloseError {
+	if m.limits.IdleTimeout <= 0 && m.limits.MaxDuration <= 0 {
+		<-ctx.Done()
+		return nil
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var idleWarned, maxWarned bool
+	for {
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			return nil
+		case now = <-ticker.C:
+		}
+
+		if m.limits.MaxDuration > 0 {
+			remaining := m.limits.MaxDuration - now.Sub(m.started)
+			if remaining <= 0 {
+				return &websocket.CloseError{Code: closeSessionExpired, Text: fmt.Sprintf("maximum session duration of %s reached", m.limits.MaxDuration)}
+			}
+			if !maxWarned && remaining <= m.limits.Warning {
+				maxWarned = true
+				warn(fmt.Sprintf("session warning: maximum session duration reached, disconnecting in %s", remaining.Round(time.Second)))
+			}
+		}
+
+		if m.limits.IdleTimeout > 0 {
+			remaining := m.limits.IdleTimeout - now.Sub(time.Unix(0, m.lastInput.Load()))
+			if remaining <= 0 {
+				return &websocket.CloseError{Code: closeSessionIdle, Text: fmt.Sprintf("session closed after %s without input", m.limits.IdleTimeout)}
+			}
+			if remaining > m.limits.Warning {
+				idleWarned = false
+			} else if !idleWarned {
+				idleWarned = true
+				warn(fmt.Sprintf("session warning: no input received, disconnecting in %s", remaining.Round(time.Second)))
+			}
+		}
+	}
+}
+
+// isVNCUpdateRequest reports whether an RFB client payload only contains
+// FramebufferUpdateRequest messages, which viewers send on their own and
+// therefore do not count as user input.
+func isVNCUpdateRequest(payload []byte) bool {
+	if len(payload) == 0 || len(payload)%10 != 0 {
+		return false
+	}
+	for i := 0; i < len(payload); i += 10 {
+		if payload[i] != 3 {
+			return false
+		}
+	}
+	return true
+}
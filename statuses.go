@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kvv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+)
+
+var (
+	// extraStatuses are offered in the status filter next to KubeVirt's.
+	extraStatuses []string
+	// statusesFile holds more statuses, one per line, usually mounted from
+	// a ConfigMap. It is read on every request so edits apply without a
+	// restart.
+	statusesFile string
+)
+
+// kubevirtStatuses are all VirtualMachinePrintableStatus values, in the order
+// the status filter offers them.
+var kubevirtStatuses = []kvv1.VirtualMachinePrintableStatus{
+	kvv1.VirtualMachineStatusRunning,
+	kvv1.VirtualMachineStatusStopped,
+	kvv1.VirtualMachineStatusProvisioning,
+	kvv1.VirtualMachineStatusStarting,
+	kvv1.VirtualMachineStatusStopping,
+	kvv1.VirtualMachineStatusMigrating,
+	kvv1.VirtualMachineStatusPaused,
+	kvv1.VirtualMachineStatusTerminating,
+	kvv1.VirtualMachineStatusCrashLoopBackOff,
+	kvv1.VirtualMachineStatusUnschedulable,
+	kvv1.VirtualMachineStatusErrImagePull,
+	kvv1.VirtualMachineStatusImagePullBackOff,
+	kvv1.VirtualMachineStatusPvcNotFound,
+	kvv1.VirtualMachineStatusDataVolumeError,
+	kvv1.VirtualMachineStatusWaitingForVolumeBinding,
+	kvv1.VirtualMachineStatusWaitingForReceiver,
+	kvv1.VirtualMachineStatusUnknown,
+}
+
+type statusCount struct {
+	Status string `json:"status"`
+	Count  int    `json:"count"`
+}
+
+// handleVMStatuses lists every known VM status with the number of VMs in
+// that status in the namespace parameter, like /api/v1/vms. Statuses found
+// in the cluster but unknown to this build are appended. When the VMs cannot
+// be listed the catalogue is still returned, without counts and with the
+// error, so the status filter keeps working.
+func handleVMStatuses(client kubecli.KubevirtClient, vmCache *vmCache, defaultNs string, w http.ResponseWriter, r *http.Request) {
+	ns := resolveNamespace(r.URL.Query().Get("namespace"), defaultNs)
+	resp := map[string]interface{}{}
+	vms, err := loadVMs(r.Context(), client, vmCache, ns)
+	if err != nil {
+		resp["error"] = err.Error()
+	}
+	counts := make(map[string]int)
+	for _, vm := range vms {
+		counts[string(vm.Status.PrintableStatus)]++
+	}
+
+	items := make([]statusCount, 0, len(kubevirtStatuses)+len(counts))
+	seen := make(map[string]bool)
+	add := func(status string) {
+		if status != "" && !seen[status] {
+			seen[status] = true
+			items = append(items, statusCount{Status: status, Count: counts[status]})
+		}
+	}
+	for _, status := range kubevirtStatuses {
+		add(string(status))
+	}
+	for _, status := range configuredStatuses() {
+		add(status)
+	}
+	var unknown []string
+	for status := range counts {
+		if !seen[status] {
+			unknown = append(unknown, status)
+		}
+	}
+	sort.Strings(unknown)
+	for _, status := range unknown {
+		add(status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	resp["items"], resp["total"] = items, len(vms)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// configuredStatuses returns --vm-statuses followed by the entries of
+// --vm-statuses-file.
+func configuredStatuses() []string {
+	statuses := append([]string{}, extraStatuses...)
+	if statusesFile == "" {
+		return statuses
+	}
+	f, err := os.Open(statusesFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read VM statuses from %s: %v", statusesFile, err)
+		}
+		return statuses
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			statuses = append(statuses, line)
+		}
+	}
+	return statuses
+}
+
+// loadVMs returns the VMs of a namespace from the informer cache when it is
+// synced, otherwise from the API server.
+func loadVMs(ctx context.Context, client kubecli.KubevirtClient, vmCache *vmCache, ns string) ([]kvv1.VirtualMachine, error) {
+	if vmCache != nil && vmCache.waitForSync(ctx, vmCacheSyncTimeout) {
+		return vmCache.listVMs(ns), nil
+	}
+	vms, err := client.VirtualMachine(ns).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return vms.Items, nil
+}
@@ -3,7 +3,7 @@ import { BrowserRouter, Routes, Route, Link, useParams, useNavigate, Navigate }
 import {
   Cpu, Terminal, ChevronLeft, FileCode, Info, Network, HardDrive,
   Layers, ShieldCheck, Server, Database, Hash, Bell, Clock, TrendingUp, BarChart3,
-  Search, Box, Filter, Check, Copy, MousePointer2, RefreshCw
+  Search, Box, Filter, Check, Copy, MousePointer2, RefreshCw, Download
 } from "lucide-react";
 import { cn } from "@/lib/utils";
 import { XAxis, YAxis, CartesianGrid, Tooltip, ResponsiveContainer, AreaChart, Area } from "recharts";
@@ -45,6 +45,7 @@ type UnknownRecord = Record<string, unknown>;
 interface VmDisk { name?: string; bootOrder?: number; disk?: UnknownRecord; cdrom?: UnknownRecord; lun?: UnknownRecord }
 interface VmVolume { name: string; dataVolume?: { name: string }; persistentVolumeClaim?: { claimName?: string }; containerDisk?: { image?: string }; cloudInitNoCloud?: UnknownRecord }
 interface VM { metadata: ResourceMetadata; spec: { running?: boolean; runStrategy?: string; instancetype?: { name?: string }; template?: { metadata?: { labels?: Record<string, string>; annotations?: Record<string, string>; }; spec?: { architecture?: string; priorityClassName?: string; domain?: { machine?: { type?: string }; cpu?: { cores?: number, sockets?: number, threads?: number }; resources?: { requests?: { cpu?: string, memory?: string } }; devices?: { interfaces?: Array<{ name: string, model?: string }>; disks?: VmDisk[] }; }; networks?: Array<{ name: string, pod?: unknown }>; volumes?: VmVolume[]; }; }; }; status?: { printableStatus?: string; conditions?: Array<{ type: string; status: string; message?: string; reason?: string }>; }; }
+interface VMSummary { name: string; namespace: string; uid: string; creationTimestamp: string; labels?: Record<string, string>; status: string; ready: boolean; runStrategy?: string; instanceType?: string; cpu?: number; memoryBytes?: number; phase?: string; phaseTransitions?: Array<{ phase: string; time: string }>; node?: string; ips?: string[]; guestOS?: string; liveMigratable?: { status: string; reason?: string; message?: string }; launcherPod?: { name: string; phase: string; ready: boolean; restarts: number }; }
 interface VMI { metadata: ResourceMetadata; status: { phase: string; interfaces?: Array<{ ipAddress?: string; name: string }>; nodeName?: string }; }
 interface DVStorageSpec { storageClassName?: string; accessModes?: string[]; volumeMode?: string; resources?: { requests?: { storage?: string } } }
 interface DV { metadata: ResourceMetadata; status?: { phase: string; progress?: string; claimName?: string; conditions?: Array<{ type: string; status: string; message?: string; reason?: string }>; }; spec: { storage?: DVStorageSpec; pvc?: DVStorageSpec; source?: Record<string, unknown>; }; }
@@ -61,6 +62,12 @@ const apiFetch = (url: string, options: RequestInit = {}) => {
   if (ctx) headers.set("X-Kube-Context", ctx);
   return fetch(url, { ...options, headers });
 };
+// Namespace the selected context defaults to, e.g. from its kubeconfig entry.
+const contextNamespace = async (): Promise<string> => {
+  const res = await fetch("/api/v1/contexts"); if (!res.ok) return "";
+  const data = await res.json() as { default?: string; items?: Array<{ name: string; namespace?: string }> };
+  const ctx = getContext() || data.default; return data.items?.find((item) => item.name === ctx)?.namespace || "";
+};
 const parseStorage = (s?: string): number => { if (!s) return 0; const num = parseFloat(s); if (s.endsWith("Ti")) return num * 1024; if (s.endsWith("Gi")) return num; if (s.endsWith("Mi")) return num / 1024; return num / (1024 * 1024); };
 const formatStorage = (gi: number): string => gi >= 1024 ? `${(gi / 1024).toFixed(1)}Ti` : `${gi.toFixed(1)}Gi`;
 const asRecord = (value: unknown): UnknownRecord => value && typeof value === "object" && !Array.isArray(value) ? value as UnknownRecord : {};
@@ -209,21 +216,42 @@ function VmActionDialog({
 
 // --- Main Views ---
 function VMList() {
-  const [vms, setVms] = useState<VM[]>([]); const [loading, setLoading] = useState(true); const [nss, setNss] = useState<string[]>(["all", "default"]); const [availableS, setAvailableS] = useState<string[]>(["all"]); const [sT, setST] = useState(""); const [nF, setNF] = useState("default"); const [sF, setSF] = useState("all");
-  const fetchVms = useCallback(async () => { setLoading(true); try { const res = await apiFetch(`/api/v1/vms?name=${sT}&status=${sF}&namespace=${nF}`); const data = await res.json(); setVms(data.items || []); } finally { setLoading(false); } }, [nF, sF, sT]);
-  const deleteVmRequest = (vm: VM) => ({
-    url: `/apis/kubevirt.io/v1/namespaces/${vm.metadata.namespace}/virtualmachines/${vm.metadata.name}`,
+  const [vms, setVms] = useState<VMSummary[]>([]); const [loading, setLoading] = useState(true); const [nss, setNss] = useState<string[]>(["all", "default"]); const [availableS, setAvailableS] = useState<Array<{ status: string; count: number }>>([]); const [sT, setST] = useState(""); const [nF, setNF] = useState(""); const [sF, setSF] = useState("all"); const [cF, setCF] = useState("");
+  const fetchVms = useCallback(async () => { setLoading(true); try { const res = await apiFetch(`/api/v1/vms?${new URLSearchParams({ name: sT, status: sF, namespace: nF, condition: cF })}`); const data = await res.json(); setVms(data.items || []); } finally { setLoading(false); } }, [cF, nF, sF, sT]);
+  const deleteVmRequest = (vm: VMSummary) => ({
+    url: `/apis/kubevirt.io/v1/namespaces/${vm.namespace}/virtualmachines/${vm.name}`,
     options: { method: "DELETE", headers: { Accept: "application/json" } },
   });
   useEffect(() => {
-    apiFetch("/api/v1/namespaces-list").then(r => r.json()).then((data) => {
+    Promise.all([apiFetch("/api/v1/namespaces-list").then(r => r.json()), contextNamespace()]).then(([data, ctxNs]) => {
       const next = Array.from(new Set(["all", ...(data || []).filter(Boolean)]));
       setNss(next);
-      setNF((current) => current && next.includes(current) ? current : next.includes("default") ? "default" : next.find((ns) => ns !== "all") || "all");
+      setNF((current) => current && next.includes(current) ? current : ctxNs && next.includes(ctxNs) ? ctxNs : next.includes("default") ? "default" : next.find((ns) => ns !== "all") || "all");
     });
-    apiFetch("/api/v1/vm-statuses").then(r => r.json()).then(setAvailableS);
   }, []);
-  useEffect(() => { const timer = setTimeout(fetchVms, 300); return () => clearTimeout(timer); }, [fetchVms]);
+  useEffect(() => { if (!nF) return; apiFetch(`/api/v1/vm-statuses?namespace=${nF}`).then(r => r.ok ? r.json() : { items: [] }).then((data) => setAvailableS(data.items || [])); }, [nF]);
+  // Wait for the namespace to be resolved, an empty one would fall back to the context namespace.
+  useEffect(() => { if (!nF) return; const timer = setTimeout(fetchVms, 300); return () => clearTimeout(timer); }, [fetchVms, nF]);
+  // VM changes are pushed by the server, so the table follows the cluster without polling.
+  useEffect(() => {
+    if (!nF) return;
+    let source: EventSource | undefined;
+    let retry = 1000;
+    const connect = () => {
+      const params = new URLSearchParams({ name: sT, status: sF, namespace: nF, condition: cF }); const ctx = getContext(); if (ctx) params.set("context", ctx);
+      source = new EventSource(`/api/v1/vms/stream?${params}`);
+      const parse = (event: Event) => JSON.parse((event as MessageEvent<string>).data) as VMSummary;
+      const upsert = (event: Event) => { const vm = parse(event); setVms((current) => { const i = current.findIndex((v) => v.uid === vm.uid); if (i === -1) return [vm, ...current]; const next = [...current]; next[i] = vm; return next; }); };
+      source.addEventListener("added", upsert);
+      source.addEventListener("modified", upsert);
+      source.addEventListener("deleted", (event) => { const vm = parse(event); setVms((current) => current.filter((v) => v.uid !== vm.uid)); });
+      source.addEventListener("synced", () => { retry = 1000; });
+      // The server ended the stream: refetch, then reconnect after a growing delay instead of letting EventSource retry at once.
+      source.addEventListener("reset", () => { source?.close(); fetchVms(); timer = setTimeout(connect, retry); retry = Math.min(retry * 2, 30000); });
+    };
+    let timer = setTimeout(connect, 300);
+    return () => { clearTimeout(timer); source?.close(); };
+  }, [fetchVms, cF, nF, sF, sT]);
   return (
     <div className="space-y-4 animate-in fade-in duration-500">
       <div className="flex flex-col gap-3 md:flex-row md:items-end md:justify-between">
@@ -243,6 +271,10 @@ function VMList() {
             <RefreshCw className={cn("h-4 w-4", loading && "animate-spin")} />
             Refresh
           </Button>
+          <Button size="sm" variant="outline" className="gap-2" onClick={() => { const params = new URLSearchParams({ name: sT, status: sF, namespace: nF, condition: cF, format: "csv" }); const ctx = getContext(); if (ctx) params.set("context", ctx); window.location.href = `/api/v1/vms/export?${params}`; }}>
+            <Download className="h-4 w-4" />
+            Export
+          </Button>
           <div className="flex items-center gap-2">
             <Box className="h-4 w-4 text-muted-foreground" />
             <select className="h-9 rounded-md border border-input bg-background px-3 text-sm outline-none focus:ring-2 focus:ring-ring text-foreground min-w-[140px]" value={nF} onChange={e => setNF(e.target.value)}>
@@ -252,7 +284,16 @@ function VMList() {
           <div className="flex items-center gap-2">
             <Filter className="h-4 w-4 text-muted-foreground" />
             <select className="h-9 rounded-md border border-input bg-background px-3 text-sm outline-none focus:ring-2 focus:ring-ring text-foreground min-w-[140px]" value={sF} onChange={e => setSF(e.target.value)}>
-              {availableS.map(s => <option key={s} value={s.toLowerCase()}>{s}</option>)}
+              <option value="all">all</option>
+              {availableS.map(s => <option key={s.status} value={s.status.toLowerCase()}>{s.count ? `${s.status} (${s.count})` : s.status}</option>)}
+            </select>
+            <select className="h-9 rounded-md border border-input bg-background px-3 text-sm outline-none focus:ring-2 focus:ring-ring text-foreground min-w-[140px]" value={cF} onChange={e => setCF(e.target.value)}>
+              <option value="">any condition</option>
+              <option value="Ready">Ready</option>
+              <option value="!Ready">Not ready</option>
+              <option value="Paused">Paused</option>
+              <option value="AgentConnected">Agent connected</option>
+              <option value="!AgentConnected">No agent</option>
             </select>
           </div>
         </div>
@@ -266,13 +307,16 @@ function VMList() {
       </div>
       <div className="overflow-hidden rounded-lg border bg-card">
         <div className="max-h-[calc(100dvh-260px)] overflow-auto">
-          <Table className="min-w-[760px]">
+          <Table className="min-w-[960px]">
             <TableHeader className="bg-muted">
               <TableRow>
                 <TableHead className="w-10"><input type="checkbox" className="size-4 rounded border-border accent-primary" aria-label="Select all VMs" /></TableHead>
                 <TableHead className="h-9 px-3 text-xs font-semibold">Name</TableHead>
                 <TableHead className="h-9 px-3 text-xs font-semibold">Namespace</TableHead>
                 <TableHead className="h-9 px-3 text-xs font-semibold">Status</TableHead>
+                <TableHead className="h-9 px-3 text-xs font-semibold">Node</TableHead>
+                <TableHead className="h-9 px-3 text-xs font-semibold">IP Addresses</TableHead>
+                <TableHead className="h-9 px-3 text-xs font-semibold">Guest OS</TableHead>
                 <TableHead className="h-9 px-3 text-right text-xs font-semibold">Created</TableHead>
                 <TableHead className="w-20" />
               </TableRow>
@@ -280,7 +324,7 @@ function VMList() {
             <TableBody>
               {loading ? (
                 <TableRow>
-                  <TableCell colSpan={6}>
+                  <TableCell colSpan={9}>
                     <div className="flex items-center justify-center h-32 gap-2">
                       <div className="h-4 w-4 animate-spin rounded-full border-2 border-muted border-t-primary" />
                       <span className="text-sm text-muted-foreground">Loading...</span>
@@ -288,20 +332,23 @@ function VMList() {
                   </TableCell>
                 </TableRow>
               ) : vms.length === 0 ? (
-                <TableRow><TableCell colSpan={6} className="h-32 text-center text-muted-foreground">No virtual machines found</TableCell></TableRow>
+                <TableRow><TableCell colSpan={9} className="h-32 text-center text-muted-foreground">No virtual machines found</TableCell></TableRow>
               ) : vms.map((vm) => (
-                <TableRow key={vm.metadata.uid} className="hover:bg-muted/50">
-                  <TableCell className="h-9 px-3 py-1.5"><input type="checkbox" className="size-4 rounded border-border accent-primary" aria-label={`Select ${vm.metadata.name}`} /></TableCell>
+                <TableRow key={vm.uid} className="hover:bg-muted/50">
+                  <TableCell className="h-9 px-3 py-1.5"><input type="checkbox" className="size-4 rounded border-border accent-primary" aria-label={`Select ${vm.name}`} /></TableCell>
                   <TableCell className="h-9 px-3 py-1.5">
-                    <Link to={`/kubevirt/virtualization/virtual-machines/${vm.metadata.namespace}/${vm.metadata.name}/overview`} className="font-semibold text-primary hover:underline">{vm.metadata.name}</Link>
+                    <Link to={`/kubevirt/virtualization/virtual-machines/${vm.namespace}/${vm.name}/overview`} className="font-semibold text-primary hover:underline">{vm.name}</Link>
                   </TableCell>
-                  <TableCell className="h-9 px-3 py-1.5 text-muted-foreground text-sm">{vm.metadata.namespace}</TableCell>
-                  <TableCell className="h-9 px-3 py-1.5"><StatusBadge status={vm.status?.printableStatus} /></TableCell>
-                  <TableCell className="h-9 px-3 py-1.5 text-right text-muted-foreground text-sm tabular-nums">{vm.metadata.creationTimestamp || "N/A"}</TableCell>
+                  <TableCell className="h-9 px-3 py-1.5 text-muted-foreground text-sm">{vm.namespace}</TableCell>
+                  <TableCell className="h-9 px-3 py-1.5"><StatusBadge status={vm.status} /></TableCell>
+                  <TableCell className="h-9 px-3 py-1.5 text-muted-foreground text-sm">{vm.node || "-"}</TableCell>
+                  <TableCell className="h-9 px-3 py-1.5 text-muted-foreground text-sm font-mono">{vm.ips?.join(", ") || "-"}</TableCell>
+                  <TableCell className="h-9 px-3 py-1.5 text-muted-foreground text-sm">{vm.guestOS || "-"}</TableCell>
+                  <TableCell className="h-9 px-3 py-1.5 text-right text-muted-foreground text-sm tabular-nums">{vm.creationTimestamp || "N/A"}</TableCell>
                   <TableCell className="h-9 px-3 py-1.5 text-right">
                     <VmActionDialog
                       label="Delete"
-                      description={`Delete VirtualMachine ${vm.metadata.namespace}/${vm.metadata.name}.`}
+                      description={`Delete VirtualMachine ${vm.namespace}/${vm.name}.`}
                       fields={[]}
                       variant="destructive"
                       buildRequest={() => deleteVmRequest(vm)}
@@ -416,13 +463,13 @@ function DVList() {
 }
 
 function DashboardOverview() {
-  const [data, setData] = useState<{ vms: VM[], vmis: VMI[], dvs: DV[], nodes: K8sNode[], kvPods: K8sPod[], pods: K8sPod[], deployments: DeploymentSummary[], services: unknown[], namespaces: unknown[], events: K8sEvent[], loading: boolean }>({ vms: [], vmis: [], dvs: [], nodes: [], kvPods: [], pods: [], deployments: [], services: [], namespaces: [], events: [], loading: true });
+  const [data, setData] = useState<{ vms: VMSummary[], vmis: VMI[], dvs: DV[], nodes: K8sNode[], kvPods: K8sPod[], pods: K8sPod[], deployments: DeploymentSummary[], services: unknown[], namespaces: unknown[], events: K8sEvent[], loading: boolean }>({ vms: [], vmis: [], dvs: [], nodes: [], kvPods: [], pods: [], deployments: [], services: [], namespaces: [], events: [], loading: true });
   useEffect(() => {
     const load = async () => {
       try {
         const loadJson = (url: string) => apiFetch(url).then(r => r.ok ? r.json() : { items: [] }).catch(() => ({ items: [] }));
         const [vmsR, vmisR, dvsR, nodesR, kvPodsR, podsR, deploymentsR, servicesR, namespacesR, eventsR] = await Promise.all([
-          loadJson("/api/v1/vms"),
+          loadJson("/api/v1/vms?namespace=all"),
           loadJson("/apis/kubevirt.io/v1/virtualmachineinstances"),
           loadJson("/apis/cdi.kubevirt.io/v1beta1/datavolumes"),
           loadJson("/api/v1/nodes"),
@@ -438,7 +485,7 @@ function DashboardOverview() {
     }; load();
   }, []);
   const nodeStats = useMemo(() => { const total = data.nodes.length; const unschedulable = data.nodes.filter(n => n.spec.unschedulable).length; const ready = data.nodes.filter(n => n.status.conditions.some(c => c.type === "Ready" && c.status === "True")).length; return { total, unschedulable, ready }; }, [data.nodes]);
-  const nsAnalysis = useMemo(() => { const analysis: Record<string, { vmCount: number, storageGi: number }> = {}; data.vms.forEach(vm => { const ns = vm.metadata.namespace; if (!analysis[ns]) analysis[ns] = { vmCount: 0, storageGi: 0 }; analysis[ns].vmCount++; }); data.dvs.forEach(dv => { const ns = dv.metadata.namespace; if (!analysis[ns]) analysis[ns] = { vmCount: 0, storageGi: 0 }; const requested = dv.spec.storage?.resources?.requests?.storage || dv.spec.pvc?.resources?.requests?.storage; analysis[ns].storageGi += parseStorage(requested); }); return Object.entries(analysis).sort((a, b) => b[1].vmCount - a[1].vmCount).map(([name, stats]) => ({ name, ...stats })); }, [data.vms, data.dvs]);
+  const nsAnalysis = useMemo(() => { const analysis: Record<string, { vmCount: number, storageGi: number }> = {}; data.vms.forEach(vm => { const ns = vm.namespace; if (!analysis[ns]) analysis[ns] = { vmCount: 0, storageGi: 0 }; analysis[ns].vmCount++; }); data.dvs.forEach(dv => { const ns = dv.metadata.namespace; if (!analysis[ns]) analysis[ns] = { vmCount: 0, storageGi: 0 }; const requested = dv.spec.storage?.resources?.requests?.storage || dv.spec.pvc?.resources?.requests?.storage; analysis[ns].storageGi += parseStorage(requested); }); return Object.entries(analysis).sort((a, b) => b[1].vmCount - a[1].vmCount).map(([name, stats]) => ({ name, ...stats })); }, [data.vms, data.dvs]);
   const infraHealth = useMemo(() => { const components = ["virt-api", "virt-controller", "virt-handler"]; return components.map(c => { const pods = data.kvPods.filter(p => p.metadata.name.startsWith(c)); const healthy = pods.length > 0 && pods.every(p => p.status.phase === "Running"); return { name: c, healthy, count: pods.length }; }); }, [data.kvPods]);
   const totalStorage = nsAnalysis.reduce((acc, curr) => acc + curr.storageGi, 0);
   const workloadHealth = useMemo(() => {
@@ -34,19 +34,40 @@ export function SerialConsole({ namespace, name }: { namespace: string, name: st
 
     const protocol = window.location.protocol === "https:" ? "wss:" : "ws:";
     const ctx = localStorage.getItem("kube-context") || "";
-    const ws = new WebSocket(`${protocol}//${window.location.host}/api/v1/ws?namespace=${namespace}&vmi=${name}&type=serial&context=${ctx}`);
-    ws.binaryType = 'arraybuffer';
-    wsRef.current = ws;
+    let ws: WebSocket | null = null;
+    let disposed = false;
 
-    ws.onopen = () => { setConnStatus("connected"); term.clear(); term.focus(); };
-    ws.onmessage = (e) => {
-      if (e.data instanceof ArrayBuffer) term.write(new TextDecoder().decode(e.data));
-      else if (typeof e.data === "string" && e.data.startsWith("console error")) setConnStatus("error");
+    const connect = () => {
+      const socket = new WebSocket(`${protocol}//${window.location.host}/api/v1/ws?namespace=${namespace}&vmi=${name}&type=serial&context=${ctx}`);
+      socket.binaryType = 'arraybuffer';
+      ws = socket;
+      wsRef.current = socket;
+
+      socket.onopen = () => { setConnStatus("connected"); term.focus(); };
+      socket.onmessage = (e) => {
+        if (e.data instanceof ArrayBuffer) term.write(new TextDecoder().decode(e.data));
+        else if (typeof e.data === "string" && e.data.startsWith("console error")) setConnStatus("error");
+        else if (typeof e.data === "string" && e.data.startsWith("session warning")) term.write(`\r\n\x1b[33m${e.data}\x1b[0m\r\n`);
+      };
+      socket.onclose = (e) => {
+        if (e.reason && !disposed) term.write(`\r\n\x1b[33m${e.reason}\x1b[0m\r\n`);
+        setConnStatus("closed");
+      };
+      socket.onerror = () => setConnStatus("error");
     };
-    ws.onclose = () => setConnStatus("closed");
-    ws.onerror = () => setConnStatus("error");
 
-    term.onData((data) => { if (ws.readyState === WebSocket.OPEN) ws.send(data); });
+    // Replay the persisted guest console log (when enabled for the VMI) before attaching to the live stream.
+    fetch(`/api/v1/console-log?namespace=${namespace}&vmi=${name}&context=${ctx}`)
+      .then((res) => (res.ok ? res.json() : null))
+      .then((history: { pod: string; lines: string[] } | null) => {
+        if (disposed || !history?.lines?.length) return;
+        term.write(`\x1b[2m${history.lines.join("\r\n")}\x1b[0m\r\n`);
+        term.write(`\x1b[33m---- console log history from ${history.pod}, live console follows ----\x1b[0m\r\n`);
+      })
+      .catch(() => {})
+      .finally(() => { if (!disposed) connect(); });
+
+    term.onData((data) => { if (ws?.readyState === WebSocket.OPEN) ws.send(data); });
     
     // Enable automatic copy-on-select
     term.onSelectionChange(() => {
@@ -58,7 +79,7 @@ export function SerialConsole({ namespace, name }: { namespace: string, name: st
 
     const handleResize = () => fitAddon.fit();
     window.addEventListener("resize", handleResize);
-    return () => { ws.close(); term.dispose(); window.removeEventListener("resize", handleResize); };
+    return () => { disposed = true; ws?.close(); term.dispose(); window.removeEventListener("resize", handleResize); };
   }, [namespace, name]);
 
   return (
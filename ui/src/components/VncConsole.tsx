@@ -12,6 +12,12 @@ type RfbClient = {
   addEventListener: (type: "connect" | "disconnect", listener: (event: Event & { detail?: { clean?: boolean } }) => void) => void;
 }
 
+type ConsoleSettings = {
+  idleTimeoutSeconds: number;
+  maxDurationSeconds: number;
+  warningSeconds: number;
+}
+
 type RfbConstructor = new (target: HTMLElement, url: string, options?: { wsProtocols?: string[] }) => RfbClient;
 
 export function VncConsole({ namespace, name }: { namespace: string, name: string }) {
@@ -21,6 +27,7 @@ export function VncConsole({ namespace, name }: { namespace: string, name: strin
   const [status, setStatus] = useState<"connecting" | "connected" | "disconnected" | "error">("connecting");
   const [errorMsg, setErrorMsg] = useState("");
   const [showCADModal, setShowCADModal] = useState(false);
+  const [sessionWarning, setSessionWarning] = useState("");
 
   const toggleTheaterMode = () => setIsTheaterMode(!isTheaterMode);
   const sendCAD = () => { rfbRef.current?.sendCtrlAltDel(); setShowCADModal(false); };
@@ -54,6 +61,35 @@ export function VncConsole({ namespace, name }: { namespace: string, name: strin
     startVnc(); return () => { if (rfb) rfb.disconnect(); };
   }, [namespace, name]);
 
+  // The RFB stream cannot carry text warnings, so the page mirrors the server's idle and duration limits itself.
+  useEffect(() => {
+    const el = containerRef.current;
+    if (status !== "connected" || !el) return;
+    let settings: ConsoleSettings | null = null;
+    fetch("/api/v1/console-settings").then((res) => res.json()).then((data: ConsoleSettings) => { settings = data; }).catch(() => {});
+    const started = Date.now();
+    let lastInput = started;
+    const onInput = () => { lastInput = Date.now(); };
+    const events = ["keydown", "mousedown", "mousemove", "wheel", "touchstart"];
+    events.forEach((ev) => el.addEventListener(ev, onInput, true));
+    const timer = window.setInterval(() => {
+      if (!settings) return;
+      const now = Date.now();
+      const warnMs = settings.warningSeconds * 1000;
+      let msg = "";
+      if (settings.maxDurationSeconds > 0) {
+        const remaining = settings.maxDurationSeconds * 1000 - (now - started);
+        if (remaining <= warnMs) msg = `Maximum session duration reached, disconnecting in ${Math.max(0, Math.ceil(remaining / 1000))}s`;
+      }
+      if (!msg && settings.idleTimeoutSeconds > 0) {
+        const remaining = settings.idleTimeoutSeconds * 1000 - (now - lastInput);
+        if (remaining <= warnMs) msg = `No input received, disconnecting in ${Math.max(0, Math.ceil(remaining / 1000))}s`;
+      }
+      setSessionWarning(msg);
+    }, 1000);
+    return () => { window.clearInterval(timer); events.forEach((ev) => el.removeEventListener(ev, onInput, true)); setSessionWarning(""); };
+  }, [status]);
+
   return (
     <div className={cn("bg-card shadow-2xl overflow-hidden flex flex-col transition-all duration-300 relative", isTheaterMode ? "fixed inset-0 z-[100] h-screen w-screen rounded-none" : "rounded-lg border h-[calc(100vh-280px)] min-h-[600px] w-full")}>
       <div className="flex items-center justify-between gap-2 bg-muted/50 border-b p-3 px-5">
@@ -68,6 +104,7 @@ export function VncConsole({ namespace, name }: { namespace: string, name: strin
            </div>
          )}
       </div>
+      {sessionWarning && <div className="bg-yellow-100 dark:bg-yellow-900/30 text-yellow-700 dark:text-yellow-400 border-b px-5 py-1.5 text-[10px] font-semibold">{sessionWarning}</div>}
       <div ref={containerRef} className="flex-1 bg-background flex items-center justify-center overflow-auto pointer-events-auto" />
       {status === "error" && (
         <div className="absolute inset-0 top-12 bg-background/90 backdrop-blur-sm flex items-center justify-center p-8 z-10 text-center">
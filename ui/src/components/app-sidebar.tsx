@@ -10,6 +10,7 @@ import {
   Terminal,
 } from "lucide-react"
 import { Link, useLocation } from "react-router-dom"
+import { toast } from "sonner"
 
 import {
   Sidebar,
@@ -30,6 +31,14 @@ import {
   SelectValue,
 } from "@/components/ui/select"
 
+type ContextsEvent = {
+  contexts: string[]
+  default: string
+  added?: string[]
+  removed?: string[]
+  changed?: string[]
+}
+
 const getContext = () => localStorage.getItem("kube-context") || ""
 const setContext = (ctx: string) => localStorage.setItem("kube-context", ctx)
 
@@ -60,6 +69,19 @@ export function AppSidebar() {
       .catch(() => {})
   }, [])
 
+  // The server reloads its kubeconfig on change and pushes the new context list.
+  useEffect(() => {
+    const source = new EventSource("/api/v1/contexts/events")
+    source.addEventListener("contexts", (event) => {
+      const d = JSON.parse((event as MessageEvent<string>).data) as ContextsEvent
+      setContexts(d.contexts || [])
+      if (d.added?.length) toast.success(`Contexts added: ${d.added.join(", ")}`)
+      if (d.changed?.length) toast.info(`Contexts reloaded: ${d.changed.join(", ")}`)
+      if (d.removed?.length) toast.warning(`Contexts removed: ${d.removed.join(", ")}`)
+    })
+    return () => source.close()
+  }, [])
+
   const handleContextChange = (v: string) => {
     setContext(v)
     setCurrentCtx(v)
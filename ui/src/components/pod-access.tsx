@@ -280,7 +280,8 @@ function PodShellDialog({ pod }: { pod: PodSummary }) {
       setStatus("error")
       term.writeln("\r\nWebSocket error while connecting to pod exec.")
     }
-    websocket.onclose = () => {
+    websocket.onclose = (event) => {
+      if (event.reason) term.writeln(`\r\n${event.reason}`)
       if (websocketRef.current === websocket) setStatus("closed")
     }
   }
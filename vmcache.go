@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	kvv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+)
+
+// vmCacheIdleTimeout stops the informers of a context nobody asked about for
+// this long. Zero disables the cache and lists VMs from the API server.
+var vmCacheIdleTimeout = 10 * time.Minute
+
+// vmCacheSyncTimeout is how long a request waits for a new cache to fill
+// before it falls back to listing from the API server.
+const vmCacheSyncTimeout = 10 * time.Second
+
+// vmCache holds shared informers for the VMs and VMIs of one context.
+type vmCache struct {
+	vms       cache.SharedIndexInformer
+	vmis      cache.SharedIndexInformer
+	stop      chan struct{}
+	startedAt time.Time
+	// lastUsed and lastEvent are unix nanoseconds.
+	lastUsed  atomic.Int64
+	lastEvent atomic.Int64
+	// streams counts open watchers that keep the cache alive while idle.
+	streams atomic.Int32
+}
+
+// cacheFreshness tells clients how current a cached list is.
+type cacheFreshness struct {
+	Source          string     `json:"source"`
+	Synced          bool       `json:"synced"`
+	StartedAt       *time.Time `json:"startedAt,omitempty"`
+	LastEvent       *time.Time `json:"lastEvent,omitempty"`
+	ResourceVersion string     `json:"resourceVersion,omitempty"`
+}
+
+// newVMCache starts informers that fetch the context's current client on
+// every list and watch, so clients rebuilt after a TTL or a 401 are picked up
+// without restarting the cache.
+func newVMCache(clientFor func() (kubecli.KubevirtClient, error)) *vmCache {
+	c := &vmCache{stop: make(chan struct{}), startedAt: time.Now()}
+	indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+	c.vms = cache.NewSharedIndexInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			client, err := clientFor()
+			if err != nil {
+				return nil, err
+			}
+			return client.VirtualMachine(metav1.NamespaceAll).List(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			client, err := clientFor()
+			if err != nil {
+				return nil, err
+			}
+			return client.VirtualMachine(metav1.NamespaceAll).Watch(context.Background(), options)
+		},
+	}, &kvv1.VirtualMachine{}, 0, indexers)
+	c.vmis = cache.NewSharedIndexInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			client, err := clientFor()
+			if err != nil {
+				return nil, err
+			}
+			return client.VirtualMachineInstance(metav1.NamespaceAll).List(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			client, err := clientFor()
+			if err != nil {
+				return nil, err
+			}
+			return client.VirtualMachineInstance(metav1.NamespaceAll).Watch(context.Background(), options)
+		},
+	}, &kvv1.VirtualMachineInstance{}, 0, indexers)
+
+	touch := func() { c.lastEvent.Store(time.Now().UnixNano()) }
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { touch() },
+		UpdateFunc: func(interface{}, interface{}) { touch() },
+		DeleteFunc: func(interface{}) { touch() },
+	}
+	c.vms.AddEventHandler(handler)
+	c.vmis.AddEventHandler(handler)
+	c.touch()
+
+	go c.vms.Run(c.stop)
+	go c.vmis.Run(c.stop)
+	return c
+}
+
+func (c *vmCache) touch() {
+	c.lastUsed.Store(time.Now().UnixNano())
+}
+
+func (c *vmCache) idle(now time.Time) bool {
+	return c.streams.Load() == 0 && now.Sub(time.Unix(0, c.lastUsed.Load())) > vmCacheIdleTimeout
+}
+
+// waitForSync waits until both informers hold a full list. Only a cache
+// started less than timeout ago is waited for, so requests against an
+// unreachable cluster fail over to the API server right away.
+func (c *vmCache) waitForSync(ctx context.Context, timeout time.Duration) bool {
+	timeout -= time.Since(c.startedAt)
+	if timeout <= 0 {
+		return c.vms.HasSynced() && c.vmis.HasSynced()
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return cache.WaitForCacheSync(ctx.Done(), c.vms.HasSynced, c.vmis.HasSynced)
+}
+
+// listVMs returns the cached VMs of a namespace, or of all namespaces for "".
+func (c *vmCache) listVMs(ns string) []kvv1.VirtualMachine {
+	var objs []interface{}
+	if ns == metav1.NamespaceAll {
+		objs = c.vms.GetStore().List()
+	} else {
+		objs, _ = c.vms.GetIndexer().ByIndex(cache.NamespaceIndex, ns)
+	}
+	vms := make([]kvv1.VirtualMachine, 0, len(objs))
+	for _, obj := range objs {
+		if vm, ok := obj.(*kvv1.VirtualMachine); ok {
+			vms = append(vms, *vm)
+		}
+	}
+	return vms
+}
+
+// getVMI returns the cached VMI of a VM, or nil when it is not running.
+func (c *vmCache) getVMI(ns, name string) *kvv1.VirtualMachineInstance {
+	obj, exists, err := c.vmis.GetStore().GetByKey(ns + "/" + name)
+	if err != nil || !exists {
+		return nil
+	}
+	vmi, _ := obj.(*kvv1.VirtualMachineInstance)
+	return vmi
+}
+
+func (c *vmCache) freshness() cacheFreshness {
+	startedAt := c.startedAt
+	f := cacheFreshness{
+		Source:          "cache",
+		Synced:          c.vms.HasSynced() && c.vmis.HasSynced(),
+		StartedAt:       &startedAt,
+		ResourceVersion: c.vms.LastSyncResourceVersion(),
+	}
+	if ns := c.lastEvent.Load(); ns != 0 {
+		lastEvent := time.Unix(0, ns)
+		f.LastEvent = &lastEvent
+	}
+	return f
+}
+
+// vmCacheFor returns the informer cache of a context, starting it on first
+// use, or nil when caching is disabled.
+func (cm *ClusterManager) vmCacheFor(ctxName string) (*vmCache, error) {
+	if vmCacheIdleTimeout <= 0 {
+		return nil, nil
+	}
+	cm.mu.RLock()
+	c, ok := cm.vmCaches[ctxName]
+	cm.mu.RUnlock()
+	if ok {
+		c.touch()
+		return c, nil
+	}
+
+	// Fail fast on contexts whose clients cannot be built.
+	if _, _, _, err := cm.clientsFor(ctxName); err != nil {
+		return nil, err
+	}
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if c, ok := cm.vmCaches[ctxName]; ok {
+		c.touch()
+		return c, nil
+	}
+	log.Printf("Starting VM informers for context: %s", ctxName)
+	c = newVMCache(func() (kubecli.KubevirtClient, error) {
+		client, _, _, err := cm.clientsFor(ctxName)
+		return client, err
+	})
+	cm.vmCaches[ctxName] = c
+	return c, nil
+}
+
+// stopVMCacheLocked stops the informers of a context. cm.mu must be held for
+// writing.
+func (cm *ClusterManager) stopVMCacheLocked(ctxName string) {
+	if c, ok := cm.vmCaches[ctxName]; ok {
+		close(c.stop)
+		delete(cm.vmCaches, ctxName)
+	}
+}
+
+// reapIdleVMCaches periodically stops caches that were not used for
+// vmCacheIdleTimeout.
+func (cm *ClusterManager) reapIdleVMCaches(ctx context.Context) {
+	if vmCacheIdleTimeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			cm.mu.Lock()
+			for ctxName, c := range cm.vmCaches {
+				if c.idle(now) {
+					log.Printf("Stopping idle VM informers for context: %s", ctxName)
+					cm.stopVMCacheLocked(ctxName)
+				}
+			}
+			cm.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	kvv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+)
+
+// vmSortColumns are the values accepted by the sortBy parameter.
+var vmSortColumns = map[string]bool{
+	"name": true, "namespace": true, "status": true, "node": true, "age": true, "cpu": true, "memory": true,
+}
+
+// vmSelectableFields are the fields a fieldSelector may use, others are
+// rejected. Selectors are evaluated locally, also on the API server fallback,
+// so these need not be selectable on the API server.
+var vmSelectableFields = map[string]bool{
+	"metadata.name": true, "metadata.namespace": true, "status.printableStatus": true, "status.ready": true,
+}
+
+type vmListOptions struct {
+	namespace     string
+	filter        vmFilter
+	labelSelector labels.Selector
+	fieldSelector fields.Selector
+	sortBy        string
+	desc          bool
+	limit         int
+	// after is the position of the last VM of the previous page.
+	after *vmSortKey
+}
+
+// parseVMListOptions reads the filter, sort and paging parameters of
+// /api/v1/vms, see parseVMFilter for name, status and condition. sortBy
+// defaults to age, youngest first; order=desc reverses it. limit=0 returns
+// everything.
+func parseVMListOptions(q url.Values, defaultNs string) (vmListOptions, error) {
+	opts := vmListOptions{
+		namespace:     resolveNamespace(q.Get("namespace"), defaultNs),
+		labelSelector: labels.Everything(),
+		fieldSelector: fields.Everything(),
+		sortBy:        "age",
+	}
+	var err error
+	if opts.filter, err = parseVMFilter(q); err != nil {
+		return opts, err
+	}
+	if v := q.Get("labelSelector"); v != "" {
+		if opts.labelSelector, err = labels.Parse(v); err != nil {
+			return opts, fmt.Errorf("invalid labelSelector: %v", err)
+		}
+	}
+	if v := q.Get("fieldSelector"); v != "" {
+		if opts.fieldSelector, err = fields.ParseSelector(v); err != nil {
+			return opts, fmt.Errorf("invalid fieldSelector: %v", err)
+		}
+		for _, req := range opts.fieldSelector.Requirements() {
+			if !vmSelectableFields[req.Field] {
+				return opts, fmt.Errorf("field %q is not selectable", req.Field)
+			}
+		}
+	}
+	if v := q.Get("sortBy"); v != "" {
+		if !vmSortColumns[v] {
+			return opts, fmt.Errorf("invalid sortBy %q", v)
+		}
+		opts.sortBy = v
+	}
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.desc = true
+	default:
+		return opts, fmt.Errorf("invalid order %q", q.Get("order"))
+	}
+	if v := q.Get("limit"); v != "" {
+		if opts.limit, err = strconv.Atoi(v); err != nil || opts.limit < 0 {
+			return opts, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	if v := q.Get("continue"); v != "" {
+		if opts.after, err = decodeContinue(v, opts.sortBy, opts.desc); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// matches applies the name, status, condition and selector filters; the
+// namespace is left to the caller's list.
+func (opts vmListOptions) matches(vm *kvv1.VirtualMachine) bool {
+	return opts.filter.matches(vm) &&
+		opts.labelSelector.Matches(labels.Set(vm.Labels)) &&
+		opts.fieldSelector.Matches(vmFields(vm))
+}
+
+// vmFilter selects VMs by name, printable status and conditions.
+type vmFilter struct {
+	nameSearch string
+	// include and exclude hold lowercase printable statuses.
+	include    map[string]bool
+	exclude    map[string]bool
+	conditions []conditionFilter
+}
+
+// conditionFilter requires a VM condition to have a status, or with negate
+// to have any other status.
+type conditionFilter struct {
+	conditionType string
+	status        corev1.ConditionStatus
+	negate        bool
+}
+
+// parseVMFilter reads the name, status and condition parameters. name is a
+// case-insensitive substring. status lists printable statuses, comma
+// separated or repeated, matched exactly but case-insensitive; entries
+// prefixed with "!" are excluded, and "all" matches everything. condition
+// lists conditions that must all hold: "Ready" requires status True,
+// "Paused=False" another status, and "!AgentConnected" anything but True.
+func parseVMFilter(q url.Values) (vmFilter, error) {
+	f := vmFilter{nameSearch: strings.ToLower(q.Get("name")), include: map[string]bool{}, exclude: map[string]bool{}}
+	for _, status := range splitValues(q["status"]) {
+		status = strings.ToLower(status)
+		switch {
+		case status == "all":
+		case strings.HasPrefix(status, "!"):
+			f.exclude[strings.TrimPrefix(status, "!")] = true
+		default:
+			f.include[status] = true
+		}
+	}
+	for _, value := range splitValues(q["condition"]) {
+		cond := conditionFilter{status: corev1.ConditionTrue}
+		if strings.HasPrefix(value, "!") {
+			cond.negate = true
+			value = strings.TrimPrefix(value, "!")
+		}
+		conditionType, status, hasStatus := strings.Cut(value, "=")
+		if conditionType == "" {
+			return f, fmt.Errorf("invalid condition %q", value)
+		}
+		cond.conditionType = conditionType
+		if hasStatus {
+			switch s := corev1.ConditionStatus(status); s {
+			case corev1.ConditionTrue, corev1.ConditionFalse, corev1.ConditionUnknown:
+				cond.status = s
+			default:
+				return f, fmt.Errorf("invalid status %q for condition %s", status, conditionType)
+			}
+		}
+		f.conditions = append(f.conditions, cond)
+	}
+	return f, nil
+}
+
+// splitValues splits comma separated query values and drops empty entries.
+func splitValues(values []string) []string {
+	var out []string
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				out = append(out, v)
+			}
+		}
+	}
+	return out
+}
+
+// filterVMs keeps the VMs matching f.
+func filterVMs(vms []kvv1.VirtualMachine, f vmFilter) []kvv1.VirtualMachine {
+	filtered := make([]kvv1.VirtualMachine, 0)
+	for _, vm := range vms {
+		if f.matches(&vm) {
+			filtered = append(filtered, vm)
+		}
+	}
+	return filtered
+}
+
+func (f vmFilter) matches(vm *kvv1.VirtualMachine) bool {
+	if f.nameSearch != "" && !strings.Contains(strings.ToLower(vm.Name), f.nameSearch) {
+		return false
+	}
+	status := strings.ToLower(string(vm.Status.PrintableStatus))
+	if (len(f.include) > 0 && !f.include[status]) || f.exclude[status] {
+		return false
+	}
+	for _, cond := range f.conditions {
+		if (vmConditionStatus(vm, cond.conditionType) == cond.status) == cond.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// vmConditionStatus returns the status of a VM condition, matched
+// case-insensitive. VMI conditions such as AgentConnected are mirrored onto
+// the VM. A missing Ready condition falls back to status.ready, any other
+// missing condition is Unknown.
+func vmConditionStatus(vm *kvv1.VirtualMachine, conditionType string) corev1.ConditionStatus {
+	for _, cond := range vm.Status.Conditions {
+		if strings.EqualFold(string(cond.Type), conditionType) {
+			return cond.Status
+		}
+	}
+	if strings.EqualFold(conditionType, string(kvv1.VirtualMachineReady)) {
+		if vm.Status.Ready {
+			return corev1.ConditionTrue
+		}
+		return corev1.ConditionFalse
+	}
+	return corev1.ConditionUnknown
+}
+
+// vmiLookup returns the VMI of a VM, or nil when it is not running.
+type vmiLookup func(ns, name string) *kvv1.VirtualMachineInstance
+
+// listVMsAndVMIs returns the VMs of opts.namespace with a lookup of their
+// VMIs, from the informer cache when it is available and synced, otherwise
+// from the API server.
+func listVMsAndVMIs(ctx context.Context, client kubecli.KubevirtClient, vmCache *vmCache, opts vmListOptions) ([]kvv1.VirtualMachine, vmiLookup, cacheFreshness, error) {
+	if vmCache != nil && vmCache.waitForSync(ctx, vmCacheSyncTimeout) {
+		return vmCache.listVMs(opts.namespace), vmCache.getVMI, vmCache.freshness(), nil
+	}
+	vms, err := client.VirtualMachine(opts.namespace).List(ctx, metav1.ListOptions{LabelSelector: opts.labelSelector.String()})
+	if err != nil {
+		return nil, nil, cacheFreshness{}, err
+	}
+	// VMIs carry the VM's labels from its template, not from the VM
+	// itself, so they are listed unfiltered.
+	vmis, err := client.VirtualMachineInstance(opts.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, cacheFreshness{}, err
+	}
+	now := time.Now()
+	freshness := cacheFreshness{Source: "api", Synced: true, LastEvent: &now, ResourceVersion: vms.ResourceVersion}
+	return vms.Items, indexVMIs(vmis.Items), freshness, nil
+}
+
+// indexVMIs turns a VMI list into a vmiLookup.
+func indexVMIs(items []kvv1.VirtualMachineInstance) vmiLookup {
+	byKey := make(map[string]*kvv1.VirtualMachineInstance, len(items))
+	for i := range items {
+		byKey[items[i].Namespace+"/"+items[i].Name] = &items[i]
+	}
+	return func(ns, name string) *kvv1.VirtualMachineInstance { return byKey[ns+"/"+name] }
+}
+
+// selectVMs filters, sorts and pages VMs. It returns the page, the number of
+// VMs matching the filters and the continue token of the next page. Pages
+// continue after the sort key of the previous page's last VM, so VMs added or
+// removed in between do not shift later pages.
+func selectVMs(vms []kvv1.VirtualMachine, vmis vmiLookup, opts vmListOptions) ([]kvv1.VirtualMachine, int, string) {
+	type keyedVM struct {
+		key vmSortKey
+		vm  kvv1.VirtualMachine
+	}
+	filtered := make([]keyedVM, 0)
+	for _, vm := range vms {
+		if opts.matches(&vm) {
+			filtered = append(filtered, keyedVM{key: vmSortKeyOf(&vm, opts.sortBy, vmis), vm: vm})
+		}
+	}
+	before := func(a, b vmSortKey) bool {
+		if opts.desc {
+			return b.less(a)
+		}
+		return a.less(b)
+	}
+	sort.Slice(filtered, func(i, j int) bool { return before(filtered[i].key, filtered[j].key) })
+
+	total := len(filtered)
+	start := 0
+	if opts.after != nil {
+		start = sort.Search(total, func(i int) bool { return before(*opts.after, filtered[i].key) })
+	}
+	end := total
+	next := ""
+	if opts.limit > 0 && end-start > opts.limit {
+		end = start + opts.limit
+		next = encodeContinue(filtered[end-1].key, opts.sortBy, opts.desc)
+	}
+	page := make([]kvv1.VirtualMachine, 0, end-start)
+	for _, item := range filtered[start:end] {
+		page = append(page, item.vm)
+	}
+	return page, total, next
+}
+
+// vmSortKey orders VMs by one sort column, then by namespace and name, so
+// every VM has a distinct position.
+type vmSortKey struct {
+	Text      string `json:"text,omitempty"`
+	Number    int64  `json:"number,omitempty"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+func vmSortKeyOf(vm *kvv1.VirtualMachine, sortBy string, vmis vmiLookup) vmSortKey {
+	key := vmSortKey{Namespace: vm.Namespace, Name: vm.Name}
+	switch sortBy {
+	case "name":
+		key.Text = vm.Name
+	case "namespace":
+		key.Text = vm.Namespace
+	case "status":
+		key.Text = string(vm.Status.PrintableStatus)
+	case "node":
+		key.Text = vmNode(vm, vmis)
+	case "cpu":
+		key.Number = vmCPU(vm, vmis)
+	case "memory":
+		key.Number = vmMemory(vm, vmis)
+	default:
+		// Youngest first, like the list always was.
+		key.Number = -vm.CreationTimestamp.Unix()
+	}
+	return key
+}
+
+func (k vmSortKey) less(o vmSortKey) bool {
+	if k.Text != o.Text {
+		return k.Text < o.Text
+	}
+	if k.Number != o.Number {
+		return k.Number < o.Number
+	}
+	if k.Namespace != o.Namespace {
+		return k.Namespace < o.Namespace
+	}
+	return k.Name < o.Name
+}
+
+func vmFields(vm *kvv1.VirtualMachine) fields.Set {
+	return fields.Set{
+		"metadata.name":          vm.Name,
+		"metadata.namespace":     vm.Namespace,
+		"status.printableStatus": string(vm.Status.PrintableStatus),
+		"status.ready":           strconv.FormatBool(vm.Status.Ready),
+	}
+}
+
+func vmNode(vm *kvv1.VirtualMachine, vmis vmiLookup) string {
+	if vmi := vmis(vm.Namespace, vm.Name); vmi != nil {
+		return vmi.Status.NodeName
+	}
+	return ""
+}
+
+// vmDomain returns the domain spec that determines a VM's size: the VMI's,
+// where instance types are already applied, or else the VM template's.
+func vmDomain(vm *kvv1.VirtualMachine, vmis vmiLookup) *kvv1.DomainSpec {
+	if vmi := vmis(vm.Namespace, vm.Name); vmi != nil {
+		return &vmi.Spec.Domain
+	}
+	if vm.Spec.Template != nil {
+		return &vm.Spec.Template.Spec.Domain
+	}
+	return nil
+}
+
+// vmCPU returns the number of vCPUs, or the CPU request in millicores / 1000
+// when no topology is set.
+func vmCPU(vm *kvv1.VirtualMachine, vmis vmiLookup) int64 {
+	domain := vmDomain(vm, vmis)
+	if domain == nil {
+		return 0
+	}
+	if cpu := domain.CPU; cpu != nil && cpu.Cores+cpu.Sockets+cpu.Threads > 0 {
+		return int64(max(cpu.Cores, 1)) * int64(max(cpu.Sockets, 1)) * int64(max(cpu.Threads, 1))
+	}
+	if request, ok := domain.Resources.Requests[corev1.ResourceCPU]; ok {
+		return request.MilliValue() / 1000
+	}
+	return 0
+}
+
+// vmMemory returns the guest memory in bytes, falling back to the memory
+// request.
+func vmMemory(vm *kvv1.VirtualMachine, vmis vmiLookup) int64 {
+	domain := vmDomain(vm, vmis)
+	if domain == nil {
+		return 0
+	}
+	if domain.Memory != nil && domain.Memory.Guest != nil {
+		return domain.Memory.Guest.Value()
+	}
+	if request, ok := domain.Resources.Requests[corev1.ResourceMemory]; ok {
+		return request.Value()
+	}
+	return 0
+}
+
+// continueToken is the opaque continue parameter: the sort key of the last
+// VM returned, and the sort order it belongs to.
+type continueToken struct {
+	SortBy string    `json:"sortBy"`
+	Desc   bool      `json:"desc,omitempty"`
+	After  vmSortKey `json:"after"`
+}
+
+func encodeContinue(after vmSortKey, sortBy string, desc bool) string {
+	data, _ := json.Marshal(continueToken{SortBy: sortBy, Desc: desc, After: after})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeContinue(token, sortBy string, desc bool) (*vmSortKey, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid continue token")
+	}
+	var t continueToken
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("invalid continue token")
+	}
+	if t.SortBy != sortBy || t.Desc != desc {
+		return nil, fmt.Errorf("continue token does not match sortBy and order")
+	}
+	return &t.After, nil
+}
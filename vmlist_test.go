@@ -0,0 +1,303 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kvv1 "kubevirt.io/api/core/v1"
+)
+
+func TestParseVMListOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		defaultNs string
+		wantNs    string
+		wantSort  string
+		wantDesc  bool
+		wantLimit int
+		wantAfter *vmSortKey
+		wantErr   bool
+	}{
+		{name: "defaults", query: "", defaultNs: "team-a", wantNs: "team-a", wantSort: "age"},
+		{name: "all namespaces", query: "namespace=all", defaultNs: "team-a", wantNs: metav1.NamespaceAll, wantSort: "age"},
+		{name: "explicit namespace", query: "namespace=team-b", defaultNs: "team-a", wantNs: "team-b", wantSort: "age"},
+		{name: "sort and order", query: "sortBy=memory&order=desc", wantSort: "memory", wantDesc: true},
+		{name: "ascending", query: "sortBy=name&order=asc", wantSort: "name"},
+		{name: "paging", query: "limit=10&continue=" + encodeContinue(vmSortKey{Number: -20, Namespace: "a", Name: "b"}, "age", false), wantSort: "age", wantLimit: 10, wantAfter: &vmSortKey{Number: -20, Namespace: "a", Name: "b"}},
+		{name: "selectors", query: "labelSelector=app%3Dweb&fieldSelector=status.ready%3Dtrue", wantSort: "age"},
+		{name: "unknown sort column", query: "sortBy=disk", wantErr: true},
+		{name: "unknown order", query: "order=up", wantErr: true},
+		{name: "negative limit", query: "limit=-1", wantErr: true},
+		{name: "invalid limit", query: "limit=ten", wantErr: true},
+		{name: "invalid continue", query: "continue=!!", wantErr: true},
+		{name: "continue that is not a token", query: "continue=LTE", wantErr: true},
+		{name: "continue of another sort column", query: "sortBy=name&continue=" + encodeContinue(vmSortKey{Name: "b"}, "age", false), wantErr: true},
+		{name: "continue of another order", query: "order=desc&continue=" + encodeContinue(vmSortKey{Name: "b"}, "age", false), wantErr: true},
+		{name: "invalid label selector", query: "labelSelector=a%20in%20(", wantErr: true},
+		{name: "unselectable field", query: "fieldSelector=spec.running%3Dtrue", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("bad test query %q: %v", tt.query, err)
+			}
+			opts, err := parseVMListOptions(q, tt.defaultNs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseVMListOptions(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if opts.namespace != tt.wantNs || opts.sortBy != tt.wantSort || opts.desc != tt.wantDesc || opts.limit != tt.wantLimit || !reflect.DeepEqual(opts.after, tt.wantAfter) {
+				t.Errorf("parseVMListOptions(%q) = namespace %q sortBy %q desc %v limit %d after %+v, want %q %q %v %d %+v",
+					tt.query, opts.namespace, opts.sortBy, opts.desc, opts.limit, opts.after,
+					tt.wantNs, tt.wantSort, tt.wantDesc, tt.wantLimit, tt.wantAfter)
+			}
+		})
+	}
+}
+
+func TestSelectVMs(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newVM := func(ns, name string, age time.Duration, status kvv1.VirtualMachinePrintableStatus, labels map[string]string) kvv1.VirtualMachine {
+		return kvv1.VirtualMachine{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:         ns,
+				Name:              name,
+				Labels:            labels,
+				CreationTimestamp: metav1.NewTime(base.Add(-age)),
+			},
+			Status: kvv1.VirtualMachineStatus{PrintableStatus: status},
+		}
+	}
+	vms := []kvv1.VirtualMachine{
+		newVM("a", "web-1", 3*time.Hour, kvv1.VirtualMachineStatusRunning, map[string]string{"app": "web"}),
+		newVM("a", "web-2", 1*time.Hour, kvv1.VirtualMachineStatusStopped, map[string]string{"app": "web"}),
+		newVM("b", "db-1", 2*time.Hour, kvv1.VirtualMachineStatusRunning, map[string]string{"app": "db"}),
+		newVM("b", "web-1", 2*time.Hour, kvv1.VirtualMachineStatusRunning, nil),
+	}
+	memory := func(size string) *kvv1.VirtualMachineInstance {
+		guest := resource.MustParse(size)
+		return &kvv1.VirtualMachineInstance{Spec: kvv1.VirtualMachineInstanceSpec{Domain: kvv1.DomainSpec{Memory: &kvv1.Memory{Guest: &guest}}}}
+	}
+	running := map[string]*kvv1.VirtualMachineInstance{
+		"a/web-1": memory("4Gi"),
+		"b/db-1":  memory("8Gi"),
+		"b/web-1": memory("2Gi"),
+	}
+	vmis := func(ns, name string) *kvv1.VirtualMachineInstance { return running[ns+"/"+name] }
+	byName := func(ns, name string) string {
+		return encodeContinue(vmSortKey{Text: name, Namespace: ns, Name: name}, "name", false)
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantNames []string
+		wantTotal int
+		wantNext  string
+	}{
+		{name: "youngest first", query: "", wantNames: []string{"a/web-2", "b/db-1", "b/web-1", "a/web-1"}, wantTotal: 4},
+		{name: "desc reverses ties too", query: "order=desc", wantNames: []string{"a/web-1", "b/web-1", "b/db-1", "a/web-2"}, wantTotal: 4},
+		{name: "ties by namespace and name", query: "sortBy=name", wantNames: []string{"b/db-1", "a/web-1", "b/web-1", "a/web-2"}, wantTotal: 4},
+		{name: "by memory of the VMI", query: "sortBy=memory&order=desc", wantNames: []string{"b/db-1", "a/web-1", "b/web-1", "a/web-2"}, wantTotal: 4},
+		{name: "label selector", query: "labelSelector=app%3Dweb&sortBy=name", wantNames: []string{"a/web-1", "a/web-2"}, wantTotal: 2},
+		{name: "field selector", query: "fieldSelector=metadata.namespace%3Db&sortBy=name", wantNames: []string{"b/db-1", "b/web-1"}, wantTotal: 2},
+		{name: "first page", query: "sortBy=name&limit=3", wantNames: []string{"b/db-1", "a/web-1", "b/web-1"}, wantTotal: 4, wantNext: byName("b", "web-1")},
+		{name: "last page", query: "sortBy=name&limit=3&continue=" + byName("b", "web-1"), wantNames: []string{"a/web-2"}, wantTotal: 4},
+		{name: "continues after a removed VM", query: "sortBy=name&limit=2&continue=" + byName("a", "db-2"), wantNames: []string{"a/web-1", "b/web-1"}, wantTotal: 4, wantNext: byName("b", "web-1")},
+		{name: "past the end", query: "sortBy=name&limit=3&continue=" + byName("z", "zz"), wantNames: []string{}, wantTotal: 4},
+		{name: "descending pages", query: "sortBy=memory&order=desc&limit=2", wantNames: []string{"b/db-1", "a/web-1"}, wantTotal: 4, wantNext: encodeContinue(vmSortKey{Number: 4 << 30, Namespace: "a", Name: "web-1"}, "memory", true)},
+		{name: "next descending page", query: "sortBy=memory&order=desc&limit=2&continue=" + encodeContinue(vmSortKey{Number: 4 << 30, Namespace: "a", Name: "web-1"}, "memory", true), wantNames: []string{"b/web-1", "a/web-2"}, wantTotal: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("bad test query %q: %v", tt.query, err)
+			}
+			opts, err := parseVMListOptions(q, "all")
+			if err != nil {
+				t.Fatalf("parseVMListOptions(%q): %v", tt.query, err)
+			}
+			page, total, next := selectVMs(vms, vmis, opts)
+			names := make([]string, 0, len(page))
+			for _, vm := range page {
+				names = append(names, vm.Namespace+"/"+vm.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) || total != tt.wantTotal || next != tt.wantNext {
+				t.Errorf("selectVMs(%q) = %v, %d, %q, want %v, %d, %q", tt.query, names, total, next, tt.wantNames, tt.wantTotal, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestParseVMFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    vmFilter
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			query: "",
+			want:  vmFilter{include: map[string]bool{}, exclude: map[string]bool{}},
+		},
+		{
+			name:  "name is lowercased",
+			query: "name=Web",
+			want:  vmFilter{nameSearch: "web", include: map[string]bool{}, exclude: map[string]bool{}},
+		},
+		{
+			name:  "statuses are split, lowercased and negated",
+			query: "status=Running,!Stopped&status=Paused,,",
+			want:  vmFilter{include: map[string]bool{"running": true, "paused": true}, exclude: map[string]bool{"stopped": true}},
+		},
+		{
+			name:  "all is ignored",
+			query: "status=all",
+			want:  vmFilter{include: map[string]bool{}, exclude: map[string]bool{}},
+		},
+		{
+			name:  "conditions",
+			query: "condition=Ready,!AgentConnected,Paused=False",
+			want: vmFilter{include: map[string]bool{}, exclude: map[string]bool{}, conditions: []conditionFilter{
+				{conditionType: "Ready", status: corev1.ConditionTrue},
+				{conditionType: "AgentConnected", status: corev1.ConditionTrue, negate: true},
+				{conditionType: "Paused", status: corev1.ConditionFalse},
+			}},
+		},
+		{name: "empty condition type", query: "condition=!", wantErr: true},
+		{name: "condition without type", query: "condition==True", wantErr: true},
+		{name: "invalid condition status", query: "condition=Ready=yes", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("bad test query %q: %v", tt.query, err)
+			}
+			got, err := parseVMFilter(q)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseVMFilter(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseVMFilter(%q) = %+v, want %+v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVMFilterMatches(t *testing.T) {
+	vm := &kvv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "Web-1"},
+		Status: kvv1.VirtualMachineStatus{
+			PrintableStatus: kvv1.VirtualMachineStatusRunning,
+			Ready:           true,
+			Conditions: []kvv1.VirtualMachineCondition{
+				{Type: kvv1.VirtualMachineConditionType(kvv1.VirtualMachineInstanceAgentConnected), Status: corev1.ConditionTrue},
+				{Type: kvv1.VirtualMachinePaused, Status: corev1.ConditionFalse},
+			},
+		},
+	}
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{query: "", want: true},
+		{query: "name=web", want: true},
+		{query: "name=db", want: false},
+		{query: "status=all", want: true},
+		{query: "status=running", want: true},
+		{query: "status=Run", want: false},
+		{query: "status=Stopped,Running", want: true},
+		{query: "status=Stopped", want: false},
+		{query: "status=!Running", want: false},
+		{query: "status=!Stopped", want: true},
+		{query: "status=all,!Running", want: false},
+		{query: "condition=Ready", want: true},
+		{query: "condition=!Ready", want: false},
+		{query: "condition=agentconnected", want: true},
+		{query: "condition=Paused", want: false},
+		{query: "condition=Paused=False", want: true},
+		{query: "condition=!Paused", want: true},
+		{query: "condition=Migrating=Unknown", want: true},
+		{query: "condition=Ready,Paused", want: false},
+		{query: "name=web&status=Running&condition=Ready", want: true},
+	}
+	for _, tt := range tests {
+		q, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatalf("bad test query %q: %v", tt.query, err)
+		}
+		f, err := parseVMFilter(q)
+		if err != nil {
+			t.Fatalf("parseVMFilter(%q): %v", tt.query, err)
+		}
+		if got := f.matches(vm); got != tt.want {
+			t.Errorf("matches(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestVMConditionStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        kvv1.VirtualMachineStatus
+		conditionType string
+		want          corev1.ConditionStatus
+	}{
+		{
+			name:          "condition present",
+			status:        kvv1.VirtualMachineStatus{Conditions: []kvv1.VirtualMachineCondition{{Type: kvv1.VirtualMachinePaused, Status: corev1.ConditionTrue}}},
+			conditionType: "Paused",
+			want:          corev1.ConditionTrue,
+		},
+		{
+			name:          "type is case-insensitive",
+			status:        kvv1.VirtualMachineStatus{Conditions: []kvv1.VirtualMachineCondition{{Type: kvv1.VirtualMachinePaused, Status: corev1.ConditionFalse}}},
+			conditionType: "paused",
+			want:          corev1.ConditionFalse,
+		},
+		{
+			name:          "Ready condition wins over status.ready",
+			status:        kvv1.VirtualMachineStatus{Ready: true, Conditions: []kvv1.VirtualMachineCondition{{Type: kvv1.VirtualMachineReady, Status: corev1.ConditionFalse}}},
+			conditionType: "Ready",
+			want:          corev1.ConditionFalse,
+		},
+		{
+			name:          "missing Ready falls back to status.ready",
+			status:        kvv1.VirtualMachineStatus{Ready: true},
+			conditionType: "ready",
+			want:          corev1.ConditionTrue,
+		},
+		{
+			name:          "missing Ready on a VM that is not ready",
+			status:        kvv1.VirtualMachineStatus{},
+			conditionType: "Ready",
+			want:          corev1.ConditionFalse,
+		},
+		{
+			name:          "other missing conditions are Unknown",
+			status:        kvv1.VirtualMachineStatus{Ready: true},
+			conditionType: "AgentConnected",
+			want:          corev1.ConditionUnknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := &kvv1.VirtualMachine{Status: tt.status}
+			if got := vmConditionStatus(vm, tt.conditionType); got != tt.want {
+				t.Errorf("vmConditionStatus(%q) = %q, want %q", tt.conditionType, got, tt.want)
+			}
+		})
+	}
+}
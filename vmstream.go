@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	kvv1 "kubevirt.io/api/core/v1"
+)
+
+// vmStreamBuffer is how many events a slow client may fall behind before its
+// stream is closed; the client then reconnects and receives a fresh list.
+const vmStreamBuffer = 256
+
+type vmEvent struct {
+	kind string
+	vm   *kvv1.VirtualMachine
+}
+
+// handleVMStream serves /api/v1/vms/stream: server-sent events for the VMs
+// matching the namespace, name, status and selector parameters, with the same
+// semantics as /api/v1/vms; sorting and paging are left to the client. Every
+// matching VM is first sent as "added", followed by a "synced" event;
+// afterwards "added", "modified" and "deleted" follow the informer. A VM that
+// stops or starts matching the filter is reported as deleted or added. Events
+// carry vmSummary rows, and VMI changes are reported as "modified" events of
+// their VM. A "reset" event ends the stream when the client falls more than
+// vmStreamBuffer changes behind after the initial list, or the cache is
+// stopped; the client then refetches and reconnects.
+func (cm *ClusterManager) handleVMStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	ctxName := cm.contextNameForRequest(r)
+	opts, err := parseVMListOptions(r.URL.Query(), cm.defaultNamespace(ctxName))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	vmCache, err := cm.vmCacheFor(ctxName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if vmCache == nil {
+		http.Error(w, "VM streaming needs the VM cache, enable it with --vm-cache-idle-timeout", http.StatusServiceUnavailable)
+		return
+	}
+	vmCache.streams.Add(1)
+	defer func() {
+		vmCache.streams.Add(-1)
+		vmCache.touch()
+	}()
+
+	matches := func(vm *kvv1.VirtualMachine) bool {
+		if vm == nil || (opts.namespace != metav1.NamespaceAll && vm.Namespace != opts.namespace) {
+			return false
+		}
+		return opts.matches(vm)
+	}
+
+	// The VM and VMI handlers run on separate informer goroutines, so the
+	// overflow channel is closed at most once.
+	events := make(chan vmEvent, vmStreamBuffer)
+	overflow := make(chan struct{})
+	var overflowOnce sync.Once
+	send := func(kind string, vm *kvv1.VirtualMachine) {
+		select {
+		case events <- vmEvent{kind: kind, vm: vm}:
+		case <-overflow:
+		default:
+			overflowOnce.Do(func() { close(overflow) })
+		}
+	}
+	// The initial list may be far larger than the buffer, so it waits for the
+	// writer instead of counting as falling behind.
+	done := make(chan struct{})
+	defer close(done)
+	sendInitial := func(vm *kvv1.VirtualMachine) {
+		select {
+		case events <- vmEvent{kind: "added", vm: vm}:
+		case <-done:
+		}
+	}
+	registration, err := vmCache.vms.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			vm := asVM(obj)
+			switch {
+			case !matches(vm):
+			case isInInitialList:
+				sendInitial(vm)
+			default:
+				send("added", vm)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldVM, newVM := asVM(oldObj), asVM(newObj)
+			switch was, is := matches(oldVM), matches(newVM); {
+			case was && is:
+				send("modified", newVM)
+			case is:
+				send("added", newVM)
+			case was:
+				send("deleted", newVM)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if vm := asVM(obj); matches(vm) {
+				send("deleted", vm)
+			}
+		},
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer vmCache.vms.RemoveEventHandler(registration)
+	vmiChanged := func(obj interface{}) {
+		vmi := asVMI(obj)
+		if vmi == nil {
+			return
+		}
+		item, exists, err := vmCache.vms.GetStore().GetByKey(vmi.Namespace + "/" + vmi.Name)
+		if err != nil || !exists {
+			return
+		}
+		if vm := asVM(item); matches(vm) {
+			send("modified", vm)
+		}
+	}
+	// The initial VMIs are already part of the "added" VM rows.
+	vmiRegistration, err := vmCache.vmis.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if !isInInitialList {
+				vmiChanged(obj)
+			}
+		},
+		UpdateFunc: func(_, newObj interface{}) { vmiChanged(newObj) },
+		DeleteFunc: vmiChanged,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer vmCache.vmis.RemoveEventHandler(vmiRegistration)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	synced := time.NewTicker(100 * time.Millisecond)
+	defer synced.Stop()
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-overflow:
+			log.Printf("Closing VM stream for context %s: client fell behind", ctxName)
+			_ = writeSSE(w, "reset", map[string]string{"reason": "client fell behind"})
+			flusher.Flush()
+			return
+		case <-vmCache.stop:
+			// The context was removed or points at another cluster, or the
+			// cache went idle; the client reconnects to a new cache.
+			_ = writeSSE(w, "reset", map[string]string{"reason": "cache stopped"})
+			flusher.Flush()
+			return
+		case ev := <-events:
+			if err := writeSSE(w, ev.kind, summarizeVM(ev.vm, vmCache.getVMI, noPods)); err != nil {
+				return
+			}
+		case <-synced.C:
+			if !registration.HasSynced() || !vmiRegistration.HasSynced() {
+				continue
+			}
+			synced.Stop()
+			// The initial list is queued completely once the handlers have
+			// synced; write what is left of it before "synced".
+			for n := len(events); n > 0; n-- {
+				ev := <-events
+				if err := writeSSE(w, ev.kind, summarizeVM(ev.vm, vmCache.getVMI, noPods)); err != nil {
+					return
+				}
+			}
+			if err := writeSSE(w, "synced", vmCache.freshness()); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			vmCache.touch()
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// asVM unwraps informer objects, including tombstones of deleted VMs.
+func asVM(obj interface{}) *kvv1.VirtualMachine {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	vm, _ := obj.(*kvv1.VirtualMachine)
+	return vm
+}
+
+// asVMI unwraps informer objects like asVM.
+func asVMI(obj interface{}) *kvv1.VirtualMachineInstance {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	vmi, _ := obj.(*kvv1.VirtualMachineInstance)
+	return vmi
+}
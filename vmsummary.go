@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kvv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+)
+
+// vmSummary is a row of the VM table: a VM joined with its VMI and,
+// on request, its virt-launcher pod.
+type vmSummary struct {
+	Name              string            `json:"name"`
+	Namespace         string            `json:"namespace"`
+	UID               types.UID         `json:"uid"`
+	CreationTimestamp metav1.Time       `json:"creationTimestamp"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Status            string            `json:"status"`
+	Ready             bool              `json:"ready"`
+	RunStrategy       string            `json:"runStrategy,omitempty"`
+	InstanceType      string            `json:"instanceType,omitempty"`
+	CPU               int64             `json:"cpu,omitempty"`
+	MemoryBytes       int64             `json:"memoryBytes,omitempty"`
+	// The fields below are only set while a VMI exists.
+	Phase            string            `json:"phase,omitempty"`
+	PhaseTransitions []phaseTransition `json:"phaseTransitions,omitempty"`
+	Node             string            `json:"node,omitempty"`
+	IPs              []string          `json:"ips,omitempty"`
+	GuestOS          string            `json:"guestOS,omitempty"`
+	LiveMigratable   *conditionSummary `json:"liveMigratable,omitempty"`
+	LauncherPod      *podSummary       `json:"launcherPod,omitempty"`
+}
+
+type phaseTransition struct {
+	Phase string      `json:"phase"`
+	Time  metav1.Time `json:"time"`
+}
+
+type conditionSummary struct {
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type podSummary struct {
+	Name     string `json:"name"`
+	Phase    string `json:"phase"`
+	Ready    bool   `json:"ready"`
+	Restarts int32  `json:"restarts"`
+}
+
+// podLookup returns the launcher pod of a VMI, or nil.
+type podLookup func(vmi *kvv1.VirtualMachineInstance) *corev1.Pod
+
+func noPods(*kvv1.VirtualMachineInstance) *corev1.Pod { return nil }
+
+// summarizeVMs joins VMs with their VMIs and launcher pods.
+func summarizeVMs(vms []kvv1.VirtualMachine, vmis vmiLookup, pods podLookup) []vmSummary {
+	rows := make([]vmSummary, 0, len(vms))
+	for i := range vms {
+		rows = append(rows, summarizeVM(&vms[i], vmis, pods))
+	}
+	return rows
+}
+
+func summarizeVM(vm *kvv1.VirtualMachine, vmis vmiLookup, pods podLookup) vmSummary {
+	row := vmSummary{
+		Name:              vm.Name,
+		Namespace:         vm.Namespace,
+		UID:               vm.UID,
+		CreationTimestamp: vm.CreationTimestamp,
+		Labels:            vm.Labels,
+		Status:            string(vm.Status.PrintableStatus),
+		Ready:             vm.Status.Ready,
+		CPU:               vmCPU(vm, vmis),
+		MemoryBytes:       vmMemory(vm, vmis),
+	}
+	if runStrategy, err := vm.RunStrategy(); err == nil {
+		row.RunStrategy = string(runStrategy)
+	}
+	if vm.Spec.Instancetype != nil {
+		row.InstanceType = vm.Spec.Instancetype.Name
+	}
+
+	vmi := vmis(vm.Namespace, vm.Name)
+	if vmi == nil {
+		return row
+	}
+	row.Phase = string(vmi.Status.Phase)
+	row.Node = vmi.Status.NodeName
+	for _, transition := range vmi.Status.PhaseTransitionTimestamps {
+		row.PhaseTransitions = append(row.PhaseTransitions, phaseTransition{Phase: string(transition.Phase), Time: transition.PhaseTransitionTimestamp})
+	}
+	seen := make(map[string]bool)
+	for _, iface := range vmi.Status.Interfaces {
+		ips := iface.IPs
+		if len(ips) == 0 && iface.IP != "" {
+			ips = []string{iface.IP}
+		}
+		for _, ip := range ips {
+			if !seen[ip] {
+				seen[ip] = true
+				row.IPs = append(row.IPs, ip)
+			}
+		}
+	}
+	row.GuestOS = vmi.Status.GuestOSInfo.PrettyName
+	if row.GuestOS == "" {
+		row.GuestOS = vmi.Status.GuestOSInfo.Name
+	}
+	for _, cond := range vmi.Status.Conditions {
+		if cond.Type == kvv1.VirtualMachineInstanceIsMigratable {
+			row.LiveMigratable = &conditionSummary{Status: string(cond.Status), Reason: cond.Reason, Message: cond.Message}
+		}
+	}
+	if pod := pods(vmi); pod != nil {
+		row.LauncherPod = &podSummary{Name: pod.Name, Phase: string(pod.Status.Phase)}
+		row.LauncherPod.Ready = len(pod.Status.ContainerStatuses) > 0
+		for _, status := range pod.Status.ContainerStatuses {
+			row.LauncherPod.Ready = row.LauncherPod.Ready && status.Ready
+			row.LauncherPod.Restarts += status.RestartCount
+		}
+	}
+	return row
+}
+
+// listLauncherPods lists the virt-launcher pods of a namespace and returns a
+// lookup by VMI. While a migration runs two pods, the one on the VMI's
+// current node wins.
+func listLauncherPods(ctx context.Context, client kubecli.KubevirtClient, ns string) (podLookup, error) {
+	pods, err := client.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{LabelSelector: kvv1.AppLabel + "=virt-launcher"})
+	if err != nil {
+		return nil, fmt.Errorf("list launcher pods: %v", err)
+	}
+	byVMI := make(map[string][]*corev1.Pod, len(pods.Items))
+	for i := range pods.Items {
+		uid := pods.Items[i].Labels[kvv1.CreatedByLabel]
+		byVMI[uid] = append(byVMI[uid], &pods.Items[i])
+	}
+	return func(vmi *kvv1.VirtualMachineInstance) *corev1.Pod {
+		candidates := byVMI[string(vmi.UID)]
+		for _, pod := range candidates {
+			if pod.Spec.NodeName == vmi.Status.NodeName {
+				return pod
+			}
+		}
+		if len(candidates) > 0 {
+			return candidates[0]
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/clientcmd"
+	"kubevirt.io/client-go/kubecli"
+	kvcorev1 "kubevirt.io/client-go/kubevirt/typed/core/v1"
+)
+
+var (
+	vncListenAddr string
+	vncPort       int
+	vncViewer     string
+	vncDirect     bool
+)
+
+var vncCmd = &cobra.Command{
+	Use:   "vnc <namespace>/<vmi>",
+	Short: "Expose a VMI VNC display on a local TCP port for native viewers",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ns, vmi, err := parseVMIRef(args[0])
+		if err != nil {
+			return err
+		}
+		bridge := vncBridgeViaDashboard(ns, vmi)
+		if vncDirect {
+			if bridge, err = vncBridgeDirect(ns, vmi); err != nil {
+				return err
+			}
+		}
+		return runVNC(bridge)
+	},
+}
+
+func init() {
+	addDashboardClientFlags(vncCmd)
+	vncCmd.Flags().StringVar(&vncListenAddr, "address", "127.0.0.1", "local address to listen on")
+	vncCmd.Flags().IntVar(&vncPort, "port", 0, "local port to listen on (0 picks a free port)")
+	vncCmd.Flags().StringVar(&vncViewer, "viewer", os.Getenv("KUBEVIRT_DASHBOARD_VNC_VIEWER"), "viewer command to launch, {addr} is replaced by host:port or the address is appended (env KUBEVIRT_DASHBOARD_VNC_VIEWER)")
+	vncCmd.Flags().BoolVar(&vncDirect, "direct", false, "connect through the local kubeconfig instead of the dashboard")
+	vncCmd.Flags().StringVar(&kubeconfig, "kubeconfig", "", "absolute path to the kubeconfig file used with --direct")
+	rootCmd.AddCommand(vncCmd)
+}
+
+// vncBridge connects one accepted local client to the VMI's VNC display and
+// returns once either side is closed.
+type vncBridge func(conn net.Conn) error
+
+func vncBridgeViaDashboard(ns, vmi string) vncBridge {
+	return func(conn net.Conn) error {
+		ws, err := dialDashboard("/api/v1/ws", url.Values{
+			"namespace": {ns},
+			"vmi":       {vmi},
+			"type":      {"vnc"},
+		})
+		if err != nil {
+			return err
+		}
+		defer ws.Close()
+
+		stream := &wsStream{conn: ws}
+		errChan := make(chan error, 2)
+		go func() {
+			_, err := io.Copy(stream, conn)
+			errChan <- err
+		}()
+		go func() {
+			_, err := io.Copy(conn, stream)
+			errChan <- err
+		}()
+		return <-errChan
+	}
+}
+
+func vncBridgeDirect(ns, vmi string) (vncBridge, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kubeconfig != "" {
+		loadingRules.ExplicitPath = kubeconfig
+	}
+	configOverrides := &clientcmd.ConfigOverrides{CurrentContext: contextName}
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubecli.GetKubevirtClientFromRESTConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return func(conn net.Conn) error {
+		vnc, err := client.VirtualMachineInstance(ns).VNC(vmi)
+		if err != nil {
+			return err
+		}
+		return vnc.Stream(kvcorev1.StreamOptions{In: conn, Out: conn})
+	}, nil
+}
+
+func runVNC(bridge vncBridge) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(vncListenAddr, strconv.Itoa(vncPort)))
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	addr := listener.Addr().String()
+	log.Printf("VNC listening on %s", addr)
+
+	viewerDone := make(chan error, 1)
+	if vncViewer != "" {
+		viewer, err := viewerCommand(vncViewer, addr)
+		if err != nil {
+			return err
+		}
+		viewer.Stdout, viewer.Stderr = os.Stdout, os.Stderr
+		if err := viewer.Start(); err != nil {
+			return fmt.Errorf("start viewer: %v", err)
+		}
+		go func() {
+			viewerDone <- viewer.Wait()
+			listener.Close()
+		}()
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case viewerErr := <-viewerDone:
+				return viewerErr
+			default:
+				return err
+			}
+		}
+		log.Printf("VNC client connected from %s", conn.RemoteAddr())
+		// KubeVirt only serves one VNC client per VMI, so clients are bridged
+		// one after another.
+		if err := bridge(conn); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+			log.Printf("VNC session ended: %v", err)
+		} else {
+			log.Printf("VNC session ended")
+		}
+		conn.Close()
+	}
+}
+
+func viewerCommand(command, addr string) (*exec.Cmd, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("empty viewer command")
+	}
+	replaced := false
+	for i, arg := range args {
+		if strings.Contains(arg, "{addr}") {
+			args[i] = strings.ReplaceAll(arg, "{addr}", addr)
+			replaced = true
+		}
+	}
+	if !replaced {
+		args = append(args, addr)
+	}
+	return exec.Command(args[0], args[1:]...), nil
+}
+
+// wsStream adapts a binary websocket to an io.ReadWriter so raw RFB bytes can
+// be copied across it.
+type wsStream struct {
+	conn   *websocket.Conn
+	reader io.Reader
+}
+
+func (s *wsStream) Read(p []byte) (int, error) {
+	for {
+		if s.reader == nil {
+			messageType, reader, err := s.conn.NextReader()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+			if messageType == websocket.TextMessage {
+				// The dashboard only sends text frames to report errors.
+				msg, _ := io.ReadAll(reader)
+				return 0, errors.New(string(msg))
+			}
+			s.reader = reader
+		}
+		n, err := s.reader.Read(p)
+		if errors.Is(err, io.EOF) {
+			s.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (s *wsStream) Write(p []byte) (int, error) {
+	if err := s.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}